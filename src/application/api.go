@@ -12,9 +12,12 @@ import (
 	"storage-api/src/application/middlewares"
 	"storage-api/src/application/routers"
 	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
 )
 
 func Api() {
+	domain.CONFIG = domain.Config()
+
 	domain.Collector()
 
 	if err := domain.CustomLogger("logs/app.log"); err != nil {
@@ -22,10 +25,28 @@ func Api() {
 	}
 	defer domain.Logger.Close()
 
+	if domain.CONFIG.SoftDelete {
+		services.TrashCollector()
+	}
+
+	if services.QuotasEnabled() {
+		services.QuotaCollector()
+	}
+
+	if domain.CONFIG.InventoryInterval > 0 {
+		services.InventoryScheduler()
+	}
+
+	// Behind a proxy, ctx.IP() reads the client address from PROXY_HEADER, but
+	// only for requests coming from TRUSTED_PROXIES, so it cannot be spoofed.
 	app := fiber.New(fiber.Config{
-		JSONEncoder: json.Marshal,
-		JSONDecoder: json.Unmarshal,
-		BodyLimit:   10 << 20,
+		JSONEncoder:             json.Marshal,
+		JSONDecoder:             json.Unmarshal,
+		BodyLimit:               10 << 20,
+		ProxyHeader:             domain.CONFIG.ProxyHeader,
+		EnableTrustedProxyCheck: domain.CONFIG.ProxyHeader != "",
+		TrustedProxies:          domain.CONFIG.TrustedProxies,
+		EnableIPValidation:      domain.CONFIG.ProxyHeader != "",
 		ErrorHandler: func(ctx fiber.Ctx, err error) error {
 			result := domain.ResultData[string]()
 			result.AddError(http.StatusInternalServerError, err.Error())
@@ -88,6 +109,10 @@ func Api() {
 
 	routers.GeneralRouter(router)
 	routers.CloudflareRouter(router)
+	routers.TrashRouter(router)
+	routers.VersionRouter(router)
+	routers.InventoryRouter(router)
+	routers.TokenRouter(router)
 
 	log.Fatal(app.Listen(fmt.Sprintf(":%d", domain.CONFIG.Port)))
 }
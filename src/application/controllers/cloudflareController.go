@@ -1,36 +1,62 @@
 package controllers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/gofiber/fiber/v3"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"regexp"
 	"storage-api/src/domain"
 	"storage-api/src/infrastructure/services"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-const DefaultContentType = "application/octet-stream"
+const (
+	DefaultContentType = "application/octet-stream"
+	CustomerKeyHeader  = "X-Encryption-Key"
+)
 
 type FileInfo struct {
-	Filename     string    `json:"filename"`
-	Folder       string    `json:"folder"`
-	Size         int64     `json:"size"`
-	LastModified time.Time `json:"lastModified"`
-	Url          string    `json:"url"`
+	Key           string            `json:"key"`
+	Filename      string            `json:"filename"`
+	Folder        string            `json:"folder"`
+	Size          int64             `json:"size"`
+	LastModified  time.Time         `json:"lastModified"`
+	Url           string            `json:"url"`
+	Checksum      string            `json:"checksum,omitempty"`
+	Thumbnails    map[string]string `json:"thumbnails,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	BlurHash      string            `json:"blurhash,omitempty"`
+	DominantColor string            `json:"dominantColor,omitempty"`
 }
 
 type ICloudflareController struct {
-	storage *services.ICloudflareService
+	storage    *services.ICloudflareService
+	dedupe     *services.IDedupeService
+	duplicates *services.IDuplicateService
+	images     *services.IImageService
+	thumbnails *services.IThumbnailService
+	antivirus  *services.IAntivirusService
 }
 
 func CloudflareController() *ICloudflareController {
+	storage := services.CloudflareService()
+
 	return &ICloudflareController{
-		storage: services.CloudflareService(),
+		storage:    storage,
+		dedupe:     services.DedupeService(storage),
+		duplicates: services.DuplicateService(storage),
+		images:     services.ImageService(storage),
+		thumbnails: services.ThumbnailService(storage),
+		antivirus:  services.AntivirusService(),
 	}
 }
 
@@ -45,16 +71,27 @@ func (c *ICloudflareController) GetHomeHandler(ctx fiber.Ctx) error {
 func (c *ICloudflareController) GetFilesHandler(ctx fiber.Ctx) error {
 	result := domain.ResultData[[]FileInfo]()
 
-	fullPath := ctx.Params("*")
+	isMetadata := ctx.Query("metadata", "false")
 
-	segments := strings.Split(fullPath, "/")
-	filename := segments[len(segments)-1]
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	filename := key.Name()
 	if strings.Contains(filename, ".") {
 		result.AddError(http.StatusBadRequest, "File name is not allowed")
 		return ctx.Status(http.StatusBadRequest).JSON(result)
 	}
 
-	rawFiles, err := c.storage.GetFiles(fullPath)
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	rawFiles, err := c.storage.GetFiles(key.Prefix())
 	if err != nil {
 		result.AddError(http.StatusNotFound, err.Error())
 		return ctx.Status(http.StatusNotFound).JSON(result)
@@ -82,6 +119,11 @@ func (c *ICloudflareController) GetFilesHandler(ctx fiber.Ctx) error {
 		}
 	}
 
+	thumbnails, err := c.thumbnails.GetThumbnails(key.Prefix())
+	if err != nil {
+		domain.Logger.Error(err.Error())
+	}
+
 	files := make([]FileInfo, 0, len(rawFiles))
 	for _, rawFile := range rawFiles {
 		filePath := *rawFile.Key
@@ -105,25 +147,133 @@ func (c *ICloudflareController) GetFilesHandler(ctx fiber.Ctx) error {
 		path := fmt.Sprintf("%s/file/%s", domain.CONFIG.ApiUrl, filePath)
 
 		files = append(files, FileInfo{
+			Key:          filePath,
 			Filename:     fileName,
 			Folder:       filePath[:strings.LastIndex(filePath, "/")],
 			Url:          path,
 			Size:         *rawFile.Size,
 			LastModified: *rawFile.LastModified,
+			Thumbnails:   thumbnailUrls(thumbnails[filePath]),
 		})
 	}
 
+	c.describeFiles(storage, files, isMetadata == "true")
+
 	result.AddData(files)
 	return ctx.Status(http.StatusOK).JSON(result)
 }
 
+func (c *ICloudflareController) GetMetadataHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[FileInfo]()
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath, filename := key.String(), key.Name()
+	if filename == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	head, err := storage.HeadFile(fullPath)
+	if err != nil {
+		result.AddError(storageErrorStatus(err), err.Error())
+		return ctx.Status(storageErrorStatus(err)).JSON(result)
+	}
+
+	thumbnails, err := c.thumbnails.GetThumbnails(key.Prefix())
+	if err != nil {
+		domain.Logger.Error(err.Error())
+	}
+
+	file := FileInfo{
+		Key:        fullPath,
+		Filename:   filename,
+		Folder:     key.Folder(),
+		Url:        fmt.Sprintf("%s/file/%s", domain.CONFIG.ApiUrl, fullPath),
+		Thumbnails: thumbnailUrls(thumbnails[fullPath]),
+	}
+	if head.ContentLength != nil {
+		file.Size = *head.ContentLength
+	}
+	if head.LastModified != nil {
+		file.LastModified = *head.LastModified
+	}
+
+	describeFile(&file, head.Metadata)
+
+	result.AddData(file)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+// describeFiles completes the listed files with the data stored in their
+// metadata, which the listing does not include. The size of dedupe references
+// and of compressed or encrypted files is always the original one; the rest of
+// the data is only added when the listing asks for it. Files stored with a
+// customer key are read with the key sent in the request.
+func (c *ICloudflareController) describeFiles(storage *services.ICloudflareService, files []FileInfo, isMetadata bool) {
+	var group sync.WaitGroup
+	limit := make(chan struct{}, 8)
+
+	for index := range files {
+		group.Add(1)
+		limit <- struct{}{}
+
+		go func(file *FileInfo) {
+			defer group.Done()
+			defer func() { <-limit }()
+
+			head, err := c.storage.HeadFile(file.Key)
+			if errors.Is(err, services.ErrCustomerKeyRequired) && storage.HasCustomerKey() {
+				head, err = storage.HeadFile(file.Key)
+			}
+			if err != nil {
+				domain.Logger.Error(err.Error())
+				return
+			}
+
+			if isMetadata {
+				describeFile(file, head.Metadata)
+			} else {
+				file.Size = services.OriginalSize(file.Key, file.Size, head.Metadata)
+			}
+		}(&files[index])
+	}
+
+	group.Wait()
+}
+
+func describeFile(file *FileInfo, metadata map[string]string) {
+	file.Checksum = services.StoredChecksum(file.Key, metadata)
+	file.Size = services.OriginalSize(file.Key, file.Size, metadata)
+
+	if media := services.MediaMetadata(metadata); len(media) > 0 {
+		file.Metadata = media
+	}
+
+	file.BlurHash = metadata[services.BlurHashMetadata]
+	file.DominantColor = metadata[services.DominantColorMetadata]
+}
+
 func (c *ICloudflareController) GetFileHandler(ctx fiber.Ctx) error {
 	result := domain.ResultData[FileInfo]()
 
-	fullPath := ctx.Params("*")
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
 
-	segments := strings.Split(fullPath, "/")
-	filename := segments[len(segments)-1]
+	fullPath, filename := key.String(), key.Name()
 	if filename == "" {
 		result.AddError(http.StatusBadRequest, "File name is missing")
 		return ctx.Status(http.StatusBadRequest).JSON(result)
@@ -134,10 +284,26 @@ func (c *ICloudflareController) GetFileHandler(ctx fiber.Ctx) error {
 		return ctx.Status(http.StatusBadRequest).JSON(result)
 	}
 
-	file, err := c.storage.GetFile(fullPath)
+	options, isTransform, errOptions := imageOptions(ctx)
+	if errOptions != nil {
+		result.AddError(http.StatusBadRequest, errOptions.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
 	if err != nil {
-		result.AddError(http.StatusNotFound, err.Error())
-		return ctx.Status(http.StatusNotFound).JSON(result)
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	if isTransform {
+		return getImageVariant(ctx, services.ImageService(storage), fullPath, filename, options)
+	}
+
+	file, err := services.DedupeService(storage).GetEncodedFile(fullPath)
+	if err != nil {
+		result.AddError(storageErrorStatus(err), err.Error())
+		return ctx.Status(storageErrorStatus(err)).JSON(result)
 	}
 
 	if file == nil {
@@ -149,6 +315,17 @@ func (c *ICloudflareController) GetFileHandler(ctx fiber.Ctx) error {
 		file.ContentType = aws.String(DefaultContentType)
 	}
 
+	if encoding := file.Metadata[services.ContentEncodingMetadata]; encoding != "" {
+		ctx.Set("Vary", "Accept-Encoding")
+
+		if services.AcceptsEncoding(ctx.Get("Accept-Encoding"), encoding) {
+			ctx.Set("Content-Encoding", encoding)
+		} else if errDecode := services.DecodeFile(file); errDecode != nil {
+			result.AddError(http.StatusInternalServerError, "Error when decompressing file: "+errDecode.Error())
+			return ctx.Status(http.StatusInternalServerError).JSON(result)
+		}
+	}
+
 	ctx.Attachment(filename)
 	ctx.Status(http.StatusOK)
 	ctx.Set("Content-Type", *file.ContentType)
@@ -156,13 +333,441 @@ func (c *ICloudflareController) GetFileHandler(ctx fiber.Ctx) error {
 	return ctx.SendStream(io.NopCloser(file.Body))
 }
 
+func getImageVariant(ctx fiber.Ctx, images *services.IImageService, fullPath string, filename string, options services.IImageOptions) error {
+	result := domain.ResultData[FileInfo]()
+
+	data, contentType, err := images.GetVariant(fullPath, options)
+	if err != nil {
+		if status := storageErrorStatus(err); status != http.StatusInternalServerError {
+			result.AddError(status, err.Error())
+			return ctx.Status(status).JSON(result)
+		}
+
+		if errors.Is(err, services.ErrUnsupportedImage) {
+			result.AddError(http.StatusUnsupportedMediaType, err.Error())
+			return ctx.Status(http.StatusUnsupportedMediaType).JSON(result)
+		}
+
+		result.AddError(http.StatusUnprocessableEntity, err.Error())
+		return ctx.Status(http.StatusUnprocessableEntity).JSON(result)
+	}
+
+	extension := contentType[strings.LastIndex(contentType, "/")+1:]
+
+	ctx.Attachment(strings.TrimSuffix(filename, filepath.Ext(filename)) + "." + extension)
+	ctx.Status(http.StatusOK)
+	ctx.Set("Content-Type", contentType)
+
+	return ctx.Send(data)
+}
+
+// customerStorage returns the storage scoped to the SSE-C key sent by the client,
+// or the shared storage when the request has no key.
+func customerStorage(ctx fiber.Ctx, storage *services.ICloudflareService) (*services.ICloudflareService, error) {
+	rawKey := ctx.Get(CustomerKeyHeader)
+	if rawKey == "" {
+		return storage, nil
+	}
+
+	key, err := services.CustomerKey(rawKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.WithCustomerKey(key), nil
+}
+
+func storageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrFileNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrCustomerKeyRequired), errors.Is(err, domain.ErrInvalidKey):
+		return http.StatusBadRequest
+	case errors.Is(err, services.ErrCustomerKeyMismatch):
+		return http.StatusForbidden
+	}
+
+	return http.StatusInternalServerError
+}
+
+// restoreErrorStatus is storageErrorStatus for restores, whose other errors
+// mean that the trash item or the version does not exist.
+func restoreErrorStatus(err error) int {
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		return http.StatusInsufficientStorage
+	}
+
+	if status := storageErrorStatus(err); status != http.StatusInternalServerError {
+		return status
+	}
+
+	return http.StatusNotFound
+}
+
+func policyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrPolicyFileSize):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, domain.ErrPolicyFileType):
+		return http.StatusUnsupportedMediaType
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+func thumbnailUrls(thumbnails map[string]string) map[string]string {
+	if len(thumbnails) == 0 {
+		return nil
+	}
+
+	urls := make(map[string]string, len(thumbnails))
+	for size, key := range thumbnails {
+		urls[size] = fmt.Sprintf("%s/file/%s", domain.CONFIG.ApiUrl, key)
+	}
+
+	return urls
+}
+
+func imageOptions(ctx fiber.Ctx) (services.IImageOptions, bool, error) {
+	options := services.IImageOptions{
+		Fit:     ctx.Query("fit", services.ImageFitContain),
+		Format:  strings.ToLower(ctx.Query("format")),
+		Quality: services.DefaultImageQuality,
+	}
+
+	isTransform := false
+	for _, param := range []struct {
+		name  string
+		value *int
+	}{
+		{"width", &options.Width},
+		{"height", &options.Height},
+		{"quality", &options.Quality},
+	} {
+		rawValue := ctx.Query(param.name)
+		if rawValue == "" {
+			continue
+		}
+
+		value, err := strconv.Atoi(rawValue)
+		if err != nil {
+			return options, false, fmt.Errorf("Invalid %s value", param.name)
+		}
+
+		*param.value = value
+		isTransform = true
+	}
+
+	if options.Format == "jpg" {
+		options.Format = "jpeg"
+	}
+
+	if options.Format != "" || ctx.Query("fit") != "" {
+		isTransform = true
+	}
+
+	if !isTransform {
+		return options, false, nil
+	}
+
+	return options, true, options.Validate()
+}
+
+func (c *ICloudflareController) VerifyFileHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IChecksumVerification]()
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath, filename := key.String(), key.Name()
+	if filename == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	verification, err := services.ChecksumService(storage).Verify(fullPath)
+	if err != nil {
+		result.AddError(storageErrorStatus(err), err.Error())
+		return ctx.Status(storageErrorStatus(err)).JSON(result)
+	}
+
+	result.AddData(*verification)
+
+	if verification.Expected == "" {
+		result.AddMessage("File has no stored checksum")
+	} else if verification.Valid {
+		result.AddMessage("File checksum is valid")
+	} else {
+		result.AddError(http.StatusConflict, "File checksum does not match")
+		return ctx.Status(http.StatusConflict).JSON(result)
+	}
+
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ICloudflareController) GetPreviewHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IPreview]()
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath, filename := key.String(), key.Name()
+	if filename == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	maxBytes, err := strconv.Atoi(ctx.Query("bytes", strconv.Itoa(services.DefaultPreviewBytes)))
+	if err != nil || maxBytes < 1 || maxBytes > services.MaxPreviewBytes {
+		result.AddError(http.StatusBadRequest, fmt.Sprintf("Bytes must be between 1 and %d", services.MaxPreviewBytes))
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	lines, err := strconv.Atoi(ctx.Query("lines", "0"))
+	if err != nil || lines < 0 {
+		result.AddError(http.StatusBadRequest, "Lines must be a positive number")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	isTail := ctx.Query("tail", "false")
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	preview, err := services.PreviewService(storage).Preview(fullPath, maxBytes, lines, isTail == "true")
+	if err != nil {
+		result.AddError(storageErrorStatus(err), err.Error())
+		return ctx.Status(storageErrorStatus(err)).JSON(result)
+	}
+
+	result.AddData(*preview)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ICloudflareController) QueryFileHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IQueryResult]()
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath, filename := key.String(), key.Name()
+	if filename == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	options, err := queryOptions(ctx, filename)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	queryResult, err := services.QueryService(storage).Query(fullPath, options)
+	if err != nil {
+		switch {
+		case storageErrorStatus(err) != http.StatusInternalServerError:
+			result.AddError(storageErrorStatus(err), err.Error())
+			return ctx.Status(storageErrorStatus(err)).JSON(result)
+		case errors.Is(err, services.ErrInvalidQuery):
+			result.AddError(http.StatusBadRequest, err.Error())
+			return ctx.Status(http.StatusBadRequest).JSON(result)
+		case errors.Is(err, services.ErrInvalidContent):
+			result.AddError(http.StatusUnprocessableEntity, err.Error())
+			return ctx.Status(http.StatusUnprocessableEntity).JSON(result)
+		}
+
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(*queryResult)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func queryOptions(ctx fiber.Ctx, filename string) (services.IQueryOptions, error) {
+	options := services.IQueryOptions{
+		Format:   ctx.Query("format", services.QueryFormatByExtension(filename)),
+		Limit:    services.DefaultQueryLimit,
+		NoHeader: ctx.Query("header", "true") == "false",
+	}
+
+	if options.Format != services.QueryFormatCsv && options.Format != services.QueryFormatJsonl {
+		return options, fmt.Errorf("Format must be one of: csv, jsonl")
+	}
+
+	if rawSelect := ctx.Query("select"); rawSelect != "" {
+		for _, column := range strings.Split(rawSelect, ",") {
+			if column = strings.TrimSpace(column); column != "" {
+				options.Select = append(options.Select, column)
+			}
+		}
+	}
+
+	for _, expression := range ctx.Request().URI().QueryArgs().PeekMulti("where") {
+		condition, err := services.ParseQueryCondition(string(expression))
+		if err != nil {
+			return options, err
+		}
+
+		options.Where = append(options.Where, condition)
+	}
+
+	if rawLimit := ctx.Query("limit"); rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > services.MaxQueryLimit {
+			return options, fmt.Errorf("Limit must be between 1 and %d", services.MaxQueryLimit)
+		}
+
+		options.Limit = limit
+	}
+
+	delimiter := ctx.Query("delimiter")
+	if delimiter == "" && strings.HasSuffix(strings.ToLower(filename), ".tsv") {
+		delimiter = "\t"
+	}
+
+	switch {
+	case delimiter == "":
+	case delimiter == "\t" || delimiter == "tab":
+		options.Delimiter = '\t'
+	case len([]rune(delimiter)) == 1 && delimiter != "\"" && delimiter != "\n" && delimiter != "\r":
+		options.Delimiter = []rune(delimiter)[0]
+	default:
+		return options, fmt.Errorf("Delimiter must be a single character")
+	}
+
+	return options, nil
+}
+
+func (c *ICloudflareController) GetDedupeHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IDedupeReport]()
+
+	report, err := c.dedupe.Report()
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(*report)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ICloudflareController) GetDuplicatesHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IDuplicateReport]()
+
+	computeHash := ctx.Query("hash", "false")
+
+	prefix, err := domain.ObjectPrefix(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	report, err := c.duplicates.FindDuplicates(prefix, computeHash == "true")
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(*report)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ICloudflareController) BackfillBlurHashHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[string]()
+
+	prefix, err := domain.ObjectPrefix(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	if err = services.BackfillBlurHashes(prefix); err != nil {
+		result.AddError(http.StatusConflict, err.Error())
+		return ctx.Status(http.StatusConflict).JSON(result)
+	}
+
+	result.AddMessage("Blurhash backfill started")
+
+	return ctx.Status(http.StatusAccepted).JSON(result)
+}
+
+func (c *ICloudflareController) RotateEncryptionHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[string]()
+
+	if domain.CONFIG.EncryptionActiveKey == "" {
+		result.AddError(http.StatusBadRequest, "Encryption is not configured")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	services.RotateEncryptionKeys("")
+
+	result.AddMessage("Encryption key rotation started with key: " + domain.CONFIG.EncryptionActiveKey)
+
+	return ctx.Status(http.StatusAccepted).JSON(result)
+}
+
+func (c *ICloudflareController) GetQuotaHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IQuotaReport]()
+
+	if !services.QuotasEnabled() {
+		result.AddError(http.StatusBadRequest, "Quotas are not configured")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	result.AddData(*services.Usage())
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ICloudflareController) GetStatsHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IStats]()
+
+	isRefresh := ctx.Query("refresh", "false")
+
+	stats, err := services.StatsService(c.storage).GetStats(isRefresh == "true")
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(*stats)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
 func (c *ICloudflareController) DeleteFileHandler(ctx fiber.Ctx) error {
 	result := domain.ResultData[string]()
 
-	fullPath := ctx.Params("*")
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
 
-	segments := strings.Split(fullPath, "/")
-	filename := segments[len(segments)-1]
+	fullPath, filename := key.String(), key.Name()
 	if filename == "" {
 		result.AddError(http.StatusBadRequest, "File name is missing")
 		return ctx.Status(http.StatusBadRequest).JSON(result)
@@ -173,19 +778,57 @@ func (c *ICloudflareController) DeleteFileHandler(ctx fiber.Ctx) error {
 		return ctx.Status(http.StatusBadRequest).JSON(result)
 	}
 
-	_, err := c.storage.GetFile(fullPath)
+	storage, err := customerStorage(ctx, c.storage)
 	if err != nil {
-		result.AddError(http.StatusNotFound, err.Error())
-		return ctx.Status(http.StatusNotFound).JSON(result)
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	head, err := storage.HeadFile(fullPath)
+	if err != nil {
+		result.AddError(storageErrorStatus(err), err.Error())
+		return ctx.Status(storageErrorStatus(err)).JSON(result)
+	}
+
+	isPermanent := ctx.Query("permanent", "false")
+
+	if domain.CONFIG.SoftDelete && isPermanent != "true" {
+		id, errTrash := services.TrashService(storage).MoveToTrash(fullPath)
+		if errTrash != nil {
+			result.AddMessage("File could not be moved to trash")
+			result.AddError(http.StatusInternalServerError, errTrash.Error())
+			return ctx.Status(http.StatusInternalServerError).JSON(result)
+		}
+
+		services.RecordUsage(fullPath, -services.StoredSize(head), -1)
+
+		result.AddData(id)
+		result.AddMessage("File moved to trash successfully")
+
+		return ctx.Status(http.StatusOK).JSON(result)
 	}
 
-	_, errDelete := c.storage.DeleteFile(fullPath)
+	errDelete := services.DedupeService(storage).DeleteFile(fullPath)
 	if errDelete != nil {
 		result.AddMessage("File could not be deleted")
 		result.AddError(http.StatusInternalServerError, errDelete.Error())
 		return ctx.Status(http.StatusInternalServerError).JSON(result)
 	}
 
+	services.RecordUsage(fullPath, -services.StoredSize(head), -1)
+
+	if _, errVersions := services.VersionService(storage).DeleteVersions(fullPath); errVersions != nil {
+		domain.Logger.Error("Error deleting versions of " + fullPath + ": " + errVersions.Error())
+	}
+
+	if errVariants := c.images.DeleteVariants(fullPath); errVariants != nil {
+		domain.Logger.Error(errVariants.Error())
+	}
+
+	if errThumbnails := c.thumbnails.Delete(fullPath); errThumbnails != nil {
+		domain.Logger.Error(errThumbnails.Error())
+	}
+
 	result.AddMessage("File deleted successfully")
 
 	return ctx.Status(http.StatusOK).JSON(result)
@@ -195,6 +838,7 @@ func (c *ICloudflareController) UploadFileHandler(ctx fiber.Ctx) error {
 	result := domain.ResultData[[]FileInfo]()
 
 	isOverwrite := ctx.Query("overwrite", "false")
+	isAutoRotate := ctx.Query("autorotate", "true")
 
 	if !strings.Contains(ctx.Get("Content-Type"), "multipart/form-data") {
 		result.AddError(http.StatusBadRequest, "Request is not a multipart/form-data")
@@ -218,25 +862,74 @@ func (c *ICloudflareController) UploadFileHandler(ctx fiber.Ctx) error {
 		return ctx.Status(http.StatusBadRequest).JSON(result)
 	}
 
-	folder := rawFolder["folder"][0]
+	folder, err := services.SanitizeFolder(rawFolder["folder"][0])
+	if err != nil {
+		result.AddError(http.StatusBadRequest, "Folder name is not allowed")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	collision := ctx.Query("collision")
+	if collision == "" && isOverwrite == "true" {
+		collision = services.CollisionOverwrite
+	} else if collision == "" {
+		collision = services.CollisionStrategyFor(folder)
+	}
+
+	if !services.IsCollisionStrategy(collision) {
+		result.AddError(http.StatusBadRequest, "Collision must be one of: fail, overwrite, suffix, uuid")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
 	rawFiles := form.File["files"]
 	if len(rawFiles) == 0 {
 		result.AddError(http.StatusBadRequest, "File(s) is missing")
 		return ctx.Status(http.StatusBadRequest).JSON(result)
 	}
 
+	policy := domain.UploadPolicyFor(folder)
+	if policy != nil && policy.MaxFiles > 0 && len(rawFiles) > policy.MaxFiles {
+		result.AddError(http.StatusBadRequest, fmt.Sprintf("Too many files for folder '%s': %d (maximum %d)", policy.Folder, len(rawFiles), policy.MaxFiles))
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	isStrip := ctx.Query("strip")
+	if isStrip == "" {
+		isStrip = strconv.FormatBool(domain.FolderMatches(folder, domain.CONFIG.StripMetadataFolders))
+	}
+
+	isCompress := ctx.Query("compress")
+	if isCompress == "" {
+		isCompress = strconv.FormatBool(domain.CONFIG.CompressUploads)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	dedupe := services.DedupeService(storage)
+	versions := services.VersionService(storage)
+
+	isEncrypted := services.IsEncryptedFolder(folder)
+	isPrivate := isEncrypted || storage.HasCustomerKey()
+
+	checksums := form.Value["checksum"]
+
 	var files []FileInfo
-	for _, rawFile := range rawFiles {
-		filename := rawFile.Filename
+	for index, rawFile := range rawFiles {
+		filename, errFilename := services.SanitizeFilename(rawFile.Filename)
+		if errFilename != nil {
+			result.AddError(http.StatusBadRequest, "File name is not allowed: "+rawFile.Filename)
+			continue
+		}
+
 		contentType := rawFile.Header.Get("Content-Type")
 		size := rawFile.Size
 
-		path := fmt.Sprintf("/%s/%s", folder, filename)
-
-		if isOverwrite == "false" {
-			_, errFile := c.storage.GetFile(path)
-			if errFile != nil {
-				result.AddError(http.StatusConflict, "File already exists: "+rawFile.Filename)
+		if policy != nil {
+			if errPolicy := policy.CheckFile(filename, size); errPolicy != nil {
+				result.AddError(policyErrorStatus(errPolicy), errPolicy.Error()+": "+rawFile.Filename)
 				continue
 			}
 		}
@@ -260,8 +953,201 @@ func (c *ICloudflareController) UploadFileHandler(ctx fiber.Ctx) error {
 			contentType = DefaultContentType
 		}
 
-		_, errUpload := c.storage.UploadFile(fileData, folder, filename, contentType)
+		// The content is kept in memory, bounded by the body limit, because the scan,
+		// the type detection, the metadata removal, the compression and the
+		// encryption need all of it before the upload.
+		expectedChecksum := ""
+		if index < len(checksums) {
+			expectedChecksum = checksums[index]
+		}
+
+		data, checksum, errRead := services.ReadWithChecksum(fileData, expectedChecksum)
+		if errors.Is(errRead, services.ErrChecksumMismatch) {
+			result.AddError(http.StatusUnprocessableEntity, "Checksum mismatch: "+rawFile.Filename)
+			continue
+		}
+		if errRead != nil {
+			result.AddError(http.StatusInternalServerError, "Error when reading file: "+rawFile.Filename)
+
+			domain.Logger.Error(errRead.Error())
+
+			continue
+		}
+
+		if policy != nil {
+			if errPolicy := policy.CheckContentType(services.DetectContentType(filename, data)); errPolicy != nil {
+				result.AddError(policyErrorStatus(errPolicy), errPolicy.Error()+": "+rawFile.Filename)
+				continue
+			}
+		}
+
+		var scanMetadata map[string]string
+		if c.antivirus.Enabled() {
+			scan, errScan := c.antivirus.Scan(data)
+			if errScan != nil {
+				result.AddError(http.StatusServiceUnavailable, "Error when scanning file: "+rawFile.Filename)
+
+				domain.Logger.Error(errScan.Error())
+
+				continue
+			}
+
+			if scan.Infected {
+				if domain.CONFIG.ClamdAction == "quarantine" {
+					quarantined, errQuarantine := c.antivirus.Quarantine(storage, folder, filename, data, scan)
+					if errQuarantine != nil {
+						domain.Logger.Error("Error when quarantining " + rawFile.Filename + ": " + errQuarantine.Error())
+					} else {
+						domain.Logger.Warning("Infected file quarantined: " + quarantined)
+					}
+				}
+
+				result.AddError(http.StatusUnprocessableEntity, fmt.Sprintf("File is infected (%s): %s", scan.Signature, rawFile.Filename))
+				continue
+			}
+
+			scanMetadata = scan.Metadata()
+		}
+
+		if isStrip == "true" {
+			stripped, changed, errStrip := services.StripImageMetadata(data, isAutoRotate == "true")
+			if errStrip != nil {
+				result.AddError(http.StatusUnprocessableEntity, "Error when removing image metadata: "+rawFile.Filename)
+
+				domain.Logger.Error(errStrip.Error())
+
+				continue
+			}
+
+			if changed {
+				data = stripped
+				size = int64(len(data))
+				checksum = services.ChecksumBytes(data)
+			}
+		}
+
+		fileFolder := folder
+		if template := services.KeyTemplateFor(folder); template != "" {
+			// The hash in the key would identify the content of encrypted files.
+			if isEncrypted && strings.Contains(template, "{hash}") {
+				result.AddError(http.StatusBadRequest, "Key templates with {hash} are not allowed in encrypted folders: "+rawFile.Filename)
+				continue
+			}
+
+			var errTemplate error
+			fileFolder, filename, errTemplate = services.ApplyKeyTemplate(template, folder, filename, checksum, time.Now())
+			if errTemplate != nil {
+				result.AddError(http.StatusBadRequest, errTemplate.Error()+": "+rawFile.Filename)
+				continue
+			}
+		}
+
+		filename, isReplace, errCollision := services.ResolveCollision(storage, fileFolder, filename, collision)
+		if errCollision != nil {
+			if errors.Is(errCollision, services.ErrFileAlreadyExists) {
+				result.AddError(http.StatusConflict, "File already exists: "+rawFile.Filename)
+				continue
+			}
+
+			result.AddError(storageErrorStatus(errCollision), "Error when checking file: "+rawFile.Filename)
+
+			domain.Logger.Error(errCollision.Error())
+
+			continue
+		}
+
+		key := fileFolder + "/" + filename
+
+		// The checksum, media metadata, placeholders and thumbnails would expose the
+		// content of encrypted files, so they are only kept in the clear for plain
+		// ones. Encrypted files seal the checksum and size with their data key.
+		metadata := map[string]string{}
+		if !isEncrypted {
+			metadata[services.ChecksumMetadata] = checksum
+		}
+		for name, value := range scanMetadata {
+			metadata[name] = value
+		}
+		if !isPrivate {
+			for name, value := range services.ExtractMediaMetadata(data) {
+				metadata[name] = value
+			}
+			for name, value := range services.ImagePlaceholders(data) {
+				metadata[name] = value
+			}
+		}
+
+		stored := data
+		if isCompress == "true" && len(data) >= domain.CONFIG.CompressMinSize && services.IsCompressible(filename, data) {
+			compressed, errCompress := services.Compress(data, domain.CONFIG.CompressEncoding)
+			if errCompress != nil {
+				result.AddError(http.StatusInternalServerError, "Error when compressing file: "+rawFile.Filename)
+
+				domain.Logger.Error(errCompress.Error())
+
+				continue
+			}
+
+			if len(compressed) < len(data) {
+				stored = compressed
+				metadata[services.ContentEncodingMetadata] = domain.CONFIG.CompressEncoding
+				if !isEncrypted {
+					metadata[services.OriginalSizeMetadata] = strconv.Itoa(len(data))
+				}
+			}
+		}
+
+		if isEncrypted {
+			encrypted, encryption, errEncrypt := services.Encrypt(stored, key, services.IEncryptedContent{Checksum: checksum, Size: int64(len(data))})
+			if errEncrypt != nil {
+				result.AddError(http.StatusInternalServerError, "Error when encrypting file: "+rawFile.Filename)
+
+				domain.Logger.Error(errEncrypt.Error())
+
+				continue
+			}
+
+			stored = encrypted
+			for name, value := range encryption {
+				metadata[name] = value
+			}
+		}
+
+		// Overwrites only account for the difference with the replaced file.
+		quotaBytes, quotaObjects := int64(len(stored)), int64(1)
+		if isReplace && services.QuotasEnabled() {
+			if head, errHead := storage.HeadFile(key); errHead == nil {
+				quotaBytes -= services.StoredSize(head)
+				quotaObjects = 0
+			}
+		}
+
+		if errQuota := services.ReserveQuota(key, quotaBytes, quotaObjects); errQuota != nil {
+			result.AddError(http.StatusInsufficientStorage, errQuota.Error()+": "+rawFile.Filename)
+			continue
+		}
+
+		if isReplace && domain.CONFIG.Versioning {
+			if _, errVersion := versions.SaveVersion(key); errVersion != nil {
+				services.RecordUsage(key, -quotaBytes, -quotaObjects)
+
+				result.AddError(http.StatusInternalServerError, "Error when saving previous version: "+rawFile.Filename)
+
+				domain.Logger.Error(errVersion.Error())
+
+				continue
+			}
+		}
+
+		errUpload := dedupe.UploadFile(bytes.NewReader(stored), int64(len(stored)), fileFolder, filename, contentType, metadata)
 		if errUpload != nil {
+			services.RecordUsage(key, -quotaBytes, -quotaObjects)
+
+			if errors.Is(errUpload, services.ErrCustomerKeyMismatch) {
+				result.AddError(http.StatusForbidden, errUpload.Error()+": "+rawFile.Filename)
+				continue
+			}
+
 			result.AddError(http.StatusBadRequest, "Error when uploading file: "+rawFile.Filename)
 
 			domain.Logger.Error(errUpload.Error())
@@ -269,12 +1155,29 @@ func (c *ICloudflareController) UploadFileHandler(ctx fiber.Ctx) error {
 			continue
 		}
 
+		var thumbnails map[string]string
+		if !isPrivate {
+			var errThumbnails error
+			thumbnails, errThumbnails = c.thumbnails.Generate(key, data)
+			if errThumbnails != nil {
+				result.AddError(http.StatusInternalServerError, "Error when generating thumbnails: "+rawFile.Filename)
+
+				domain.Logger.Error(errThumbnails.Error())
+			}
+		}
+
 		files = append(files, FileInfo{
-			Filename:     filename,
-			Folder:       folder,
-			Size:         size,
-			LastModified: time.Now(),
-			Url:          domain.CONFIG.ApiUrl + "/file" + path,
+			Key:           key,
+			Filename:      filename,
+			Folder:        fileFolder,
+			Size:          size,
+			LastModified:  time.Now(),
+			Url:           domain.CONFIG.ApiUrl + "/file/" + key,
+			Checksum:      checksum,
+			Thumbnails:    thumbnailUrls(thumbnails),
+			Metadata:      services.MediaMetadata(metadata),
+			BlurHash:      metadata[services.BlurHashMetadata],
+			DominantColor: metadata[services.DominantColorMetadata],
 		})
 	}
 
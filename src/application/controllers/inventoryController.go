@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
+	"time"
+)
+
+type IInventoryController struct {
+	inventory *services.IInventoryService
+}
+
+func InventoryController() *IInventoryController {
+	storage := services.CloudflareService()
+
+	return &IInventoryController{
+		inventory: services.InventoryService(storage),
+	}
+}
+
+func (c *IInventoryController) GetSnapshotsHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[[]services.IInventorySnapshot]()
+
+	snapshots, err := c.inventory.GetSnapshots()
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(snapshots)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *IInventoryController) ExportInventoryHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[string]()
+
+	format := ctx.Query("format", domain.CONFIG.InventoryFormat)
+	if !services.IsInventoryFormat(format) {
+		result.AddError(http.StatusBadRequest, "Format must be one of: csv, jsonl")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	name := services.InventoryName(format, time.Now())
+
+	if err := services.InventoryCollector(name); err != nil {
+		result.AddError(http.StatusConflict, err.Error())
+		return ctx.Status(http.StatusConflict).JSON(result)
+	}
+
+	result.AddData(name)
+	result.AddMessage("Inventory export started")
+
+	return ctx.Status(http.StatusAccepted).JSON(result)
+}
+
+func (c *IInventoryController) DiffInventoryHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IInventoryDiff]()
+
+	from := ctx.Query("from")
+	to := ctx.Query("to")
+	if from == "" || to == "" {
+		result.AddError(http.StatusBadRequest, "Snapshots to compare are missing (from, to)")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	for _, name := range []string{from, to} {
+		if _, _, err := services.ParseInventoryName(name); err != nil {
+			result.AddError(http.StatusBadRequest, err.Error())
+			return ctx.Status(http.StatusBadRequest).JSON(result)
+		}
+	}
+
+	diff, err := c.inventory.Diff(from, to)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidInventory):
+			result.AddError(http.StatusUnprocessableEntity, err.Error())
+			return ctx.Status(http.StatusUnprocessableEntity).JSON(result)
+		case storageErrorStatus(err) != http.StatusInternalServerError:
+			result.AddError(storageErrorStatus(err), err.Error())
+			return ctx.Status(storageErrorStatus(err)).JSON(result)
+		}
+
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(*diff)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
+)
+
+// IssuedToken is returned when a token is created or rotated, the only time its
+// secret is shown.
+type IssuedToken struct {
+	services.IApiToken
+	Token string `json:"token"`
+}
+
+type ITokenController struct {
+	tokens *services.ITokenService
+}
+
+func TokenController() *ITokenController {
+	return &ITokenController{
+		tokens: services.TokenService(),
+	}
+}
+
+func (c *ITokenController) GetTokensHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[[]services.IApiToken]()
+
+	if c.tokens == nil {
+		result.AddError(http.StatusServiceUnavailable, "Token store is not available")
+		return ctx.Status(http.StatusServiceUnavailable).JSON(result)
+	}
+
+	tokens, err := c.tokens.GetTokens()
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(tokens)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ITokenController) CreateTokenHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[IssuedToken]()
+
+	if c.tokens == nil {
+		result.AddError(http.StatusServiceUnavailable, "Token store is not available")
+		return ctx.Status(http.StatusServiceUnavailable).JSON(result)
+	}
+
+	var request services.ITokenRequest
+	if err := json.Unmarshal(ctx.Body(), &request); err != nil {
+		result.AddError(http.StatusBadRequest, "The request body is not valid JSON")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	token, secret, err := c.tokens.Create(request)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidToken) {
+			result.AddError(http.StatusBadRequest, err.Error())
+			return ctx.Status(http.StatusBadRequest).JSON(result)
+		}
+
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(IssuedToken{IApiToken: *token, Token: secret})
+	result.AddMessage("Token created successfully, it will not be shown again")
+
+	return ctx.Status(http.StatusCreated).JSON(result)
+}
+
+func (c *ITokenController) RotateTokenHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[IssuedToken]()
+
+	if c.tokens == nil {
+		result.AddError(http.StatusServiceUnavailable, "Token store is not available")
+		return ctx.Status(http.StatusServiceUnavailable).JSON(result)
+	}
+
+	token, secret, err := c.tokens.Rotate(ctx.Params("id"))
+	if err != nil {
+		result.AddError(tokenErrorStatus(err), err.Error())
+		return ctx.Status(tokenErrorStatus(err)).JSON(result)
+	}
+
+	result.AddData(IssuedToken{IApiToken: *token, Token: secret})
+	result.AddMessage("Token rotated successfully, it will not be shown again")
+
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ITokenController) RevokeTokenHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[services.IApiToken]()
+
+	if c.tokens == nil {
+		result.AddError(http.StatusServiceUnavailable, "Token store is not available")
+		return ctx.Status(http.StatusServiceUnavailable).JSON(result)
+	}
+
+	token, err := c.tokens.Revoke(ctx.Params("id"))
+	if err != nil {
+		result.AddError(tokenErrorStatus(err), err.Error())
+		return ctx.Status(tokenErrorStatus(err)).JSON(result)
+	}
+
+	result.AddData(*token)
+	result.AddMessage("Token revoked successfully")
+
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func tokenErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrTokenNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, services.ErrTokenRevoked):
+		return http.StatusConflict
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,95 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
+	"strings"
+)
+
+type ITrashController struct {
+	storage *services.ICloudflareService
+	trash   *services.ITrashService
+}
+
+func TrashController() *ITrashController {
+	storage := services.CloudflareService()
+
+	return &ITrashController{
+		storage: storage,
+		trash:   services.TrashService(storage),
+	}
+}
+
+func (c *ITrashController) GetTrashHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[[]services.ITrashItem]()
+
+	items, err := c.trash.GetItems()
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(items)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *ITrashController) RestoreTrashHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[FileInfo]()
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	id := key.String()
+	if id == "" {
+		result.AddError(http.StatusBadRequest, "Trash item is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	isOverwrite := ctx.Query("overwrite", "false")
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	filePath, err := services.TrashService(storage).Restore(id, isOverwrite == "true")
+	if err != nil {
+		if errors.Is(err, services.ErrFileAlreadyExists) {
+			result.AddError(http.StatusConflict, err.Error())
+			return ctx.Status(http.StatusConflict).JSON(result)
+		}
+
+		result.AddError(restoreErrorStatus(err), err.Error())
+		return ctx.Status(restoreErrorStatus(err)).JSON(result)
+	}
+
+	// Files stored with a customer key have no thumbnails.
+	if !storage.HasCustomerKey() {
+		if errThumbnails := services.ThumbnailService(storage).Regenerate(filePath); errThumbnails != nil {
+			domain.Logger.Error(errThumbnails.Error())
+		}
+	}
+
+	folder := ""
+	if index := strings.LastIndex(filePath, "/"); index >= 0 {
+		folder = filePath[:index]
+	}
+
+	result.AddData(FileInfo{
+		Key:      filePath,
+		Filename: filePath[strings.LastIndex(filePath, "/")+1:],
+		Folder:   folder,
+		Url:      fmt.Sprintf("%s/file/%s", domain.CONFIG.ApiUrl, filePath),
+	})
+	result.AddMessage("File restored successfully")
+
+	return ctx.Status(http.StatusOK).JSON(result)
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gofiber/fiber/v3"
+	"io"
+	"net/http"
+	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
+	"strconv"
+	"time"
+)
+
+type IVersionController struct {
+	storage *services.ICloudflareService
+}
+
+func VersionController() *IVersionController {
+	return &IVersionController{
+		storage: services.CloudflareService(),
+	}
+}
+
+func (c *IVersionController) GetVersionsHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[[]services.IFileVersion]()
+
+	if !domain.CONFIG.Versioning {
+		result.AddError(http.StatusBadRequest, "Versioning is not enabled")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath := key.String()
+	if fullPath == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	versions, err := services.VersionService(c.storage).GetVersions(fullPath)
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddData(versions)
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *IVersionController) GetVersionHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[string]()
+
+	if !domain.CONFIG.Versioning {
+		result.AddError(http.StatusBadRequest, "Versioning is not enabled")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath, filename := key.String(), key.Name()
+	if filename == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	file, err := services.VersionService(storage).GetVersion(fullPath, ctx.Params("id"))
+	if err != nil {
+		result.AddError(restoreErrorStatus(err), err.Error())
+		return ctx.Status(restoreErrorStatus(err)).JSON(result)
+	}
+
+	if file.ContentType == nil {
+		file.ContentType = aws.String(DefaultContentType)
+	}
+
+	ctx.Attachment(filename)
+	ctx.Status(http.StatusOK)
+	ctx.Set("Content-Type", *file.ContentType)
+
+	return ctx.SendStream(io.NopCloser(file.Body))
+}
+
+func (c *IVersionController) RestoreVersionHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[string]()
+
+	if !domain.CONFIG.Versioning {
+		result.AddError(http.StatusBadRequest, "Versioning is not enabled")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath := key.String()
+	if fullPath == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	storage, err := customerStorage(ctx, c.storage)
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	if err := services.VersionService(storage).Restore(fullPath, ctx.Params("id")); err != nil {
+		result.AddMessage("Version could not be restored")
+		result.AddError(restoreErrorStatus(err), err.Error())
+		return ctx.Status(restoreErrorStatus(err)).JSON(result)
+	}
+
+	// Files stored with a customer key have no thumbnails.
+	if !storage.HasCustomerKey() {
+		if errThumbnails := services.ThumbnailService(storage).Regenerate(fullPath); errThumbnails != nil {
+			domain.Logger.Error(errThumbnails.Error())
+		}
+	}
+
+	result.AddMessage("Version restored successfully")
+
+	return ctx.Status(http.StatusOK).JSON(result)
+}
+
+func (c *IVersionController) PruneVersionsHandler(ctx fiber.Ctx) error {
+	result := domain.ResultData[string]()
+
+	if !domain.CONFIG.Versioning {
+		result.AddError(http.StatusBadRequest, "Versioning is not enabled")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	key, err := domain.ObjectKey(ctx.Params("*"))
+	if err != nil {
+		result.AddError(http.StatusBadRequest, err.Error())
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	fullPath := key.String()
+	if fullPath == "" {
+		result.AddError(http.StatusBadRequest, "File name is missing")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	maxCount, errCount := strconv.Atoi(ctx.Query("maxCount", strconv.Itoa(domain.CONFIG.VersionsMaxCount)))
+	if errCount != nil || maxCount < 0 {
+		result.AddError(http.StatusBadRequest, "Invalid maxCount value")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	maxAgeDays, errAge := strconv.Atoi(ctx.Query("maxAgeDays", strconv.Itoa(domain.CONFIG.VersionsMaxAgeDays)))
+	if errAge != nil || maxAgeDays < 0 {
+		result.AddError(http.StatusBadRequest, "Invalid maxAgeDays value")
+		return ctx.Status(http.StatusBadRequest).JSON(result)
+	}
+
+	pruned, err := services.VersionService(c.storage).Prune(fullPath, maxCount, time.Duration(maxAgeDays)*24*time.Hour)
+	if err != nil {
+		result.AddError(http.StatusInternalServerError, err.Error())
+		return ctx.Status(http.StatusInternalServerError).JSON(result)
+	}
+
+	result.AddMessage(fmt.Sprintf("Versions pruned successfully: %d", pruned))
+
+	return ctx.Status(http.StatusOK).JSON(result)
+}
@@ -1,15 +1,20 @@
 package middlewares
 
 import (
+	"errors"
 	"github.com/gofiber/fiber/v3"
 	"net/http"
 	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
+	"strings"
 )
 
+const ApiKeyLocal = "apiKey"
+
 func AuthMiddleware(ctx fiber.Ctx) error {
 	result := domain.ResultData[string]()
 
-	authToken := ctx.Get("Authorization")
+	authToken := strings.TrimPrefix(ctx.Get("Authorization"), "Bearer ")
 	if authToken == "" {
 		result.AddMessage("Authorization token is missing")
 
@@ -18,7 +23,31 @@ func AuthMiddleware(ctx fiber.Ctx) error {
 		return ctx.Status(http.StatusUnauthorized).JSON(result)
 	}
 
-	if authToken != domain.CONFIG.Token {
+	apiKey := domain.FindApiKey(authToken)
+
+	if tokens := services.TokenService(); apiKey == nil && tokens != nil {
+		token, err := tokens.Authenticate(authToken, ctx.IP())
+		switch {
+		case errors.Is(err, services.ErrTokenIp):
+			result.AddMessage(err.Error())
+
+			domain.Logger.Error(err.Error() + ": " + ctx.IP())
+
+			return ctx.Status(http.StatusForbidden).JSON(result)
+		case errors.Is(err, services.ErrTokenExpired), errors.Is(err, services.ErrTokenRevoked):
+			result.AddMessage(err.Error())
+
+			domain.Logger.Error(err.Error())
+
+			return ctx.Status(http.StatusUnauthorized).JSON(result)
+		case err == nil:
+			apiKey = token.ApiKey()
+		case !errors.Is(err, services.ErrTokenNotFound):
+			domain.Logger.Error("Error validating token: " + err.Error())
+		}
+	}
+
+	if apiKey == nil {
 		result.AddMessage("Authorization token is invalid")
 
 		domain.Logger.Error("Authorization token is invalid")
@@ -26,5 +55,7 @@ func AuthMiddleware(ctx fiber.Ctx) error {
 		return ctx.Status(http.StatusUnauthorized).JSON(result)
 	}
 
+	ctx.Locals(ApiKeyLocal, apiKey)
+
 	return ctx.Next()
 }
@@ -0,0 +1,69 @@
+package middlewares
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"net/http"
+	"storage-api/src/domain"
+	"storage-api/src/infrastructure/services"
+)
+
+// ScopeMiddleware allows the request when the API key has the scope and, except
+// for admin routes, when the requested path is under one of its prefixes.
+func ScopeMiddleware(scope string) fiber.Handler {
+	return func(ctx fiber.Ctx) error {
+		result := domain.ResultData[string]()
+
+		apiKey, _ := ctx.Locals(ApiKeyLocal).(*domain.IApiKey)
+		if apiKey == nil || !apiKey.HasScope(scope) {
+			result.AddMessage("API key does not have the scope: " + scope)
+
+			domain.Logger.Error("API key does not have the scope: " + scope)
+
+			return ctx.Status(http.StatusForbidden).JSON(result)
+		}
+
+		if scope == domain.ScopeAdmin {
+			return ctx.Next()
+		}
+
+		path, err := requestPath(ctx)
+		if err != nil {
+			result.AddMessage(err.Error())
+
+			domain.Logger.Error(err.Error())
+
+			return ctx.Status(http.StatusBadRequest).JSON(result)
+		}
+
+		if !apiKey.AllowsPath(path) {
+			result.AddMessage("API key is not allowed to access: /" + path)
+
+			domain.Logger.Error("API key is not allowed to access: /" + path)
+
+			return ctx.Status(http.StatusForbidden).JSON(result)
+		}
+
+		return ctx.Next()
+	}
+}
+
+// requestPath returns the canonical path of the request: the wildcard of the
+// route or, for uploads, the folder of the form as the handler will store it.
+// A path that cannot be stored is an error rather than the root, which a key
+// limited to some prefixes is never allowed to reach anyway.
+func requestPath(ctx fiber.Ctx) (string, error) {
+	if rawPath := ctx.Params("*"); rawPath != "" {
+		key, err := domain.ObjectKey(rawPath)
+		if err != nil {
+			return "", err
+		}
+
+		return key.String(), nil
+	}
+
+	if rawFolder := ctx.FormValue("folder"); rawFolder != "" {
+		return services.SanitizeFolder(rawFolder)
+	}
+
+	return "", nil
+}
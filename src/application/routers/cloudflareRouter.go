@@ -3,16 +3,28 @@ package routers
 import (
 	"github.com/gofiber/fiber/v3"
 	"storage-api/src/application/controllers"
+	"storage-api/src/application/middlewares"
+	"storage-api/src/domain"
 )
 
 func CloudflareRouter(router fiber.Router) fiber.Router {
 	controller := controllers.CloudflareController()
 
 	router.Get("/", controller.GetHomeHandler)
-	router.Get("/files/*", controller.GetFilesHandler)
-	router.Get("/file/*", controller.GetFileHandler)
-	router.Delete("/file/*", controller.DeleteFileHandler)
-	router.Post("/file", controller.UploadFileHandler)
+	router.Get("/files/*", controller.GetFilesHandler, middlewares.ScopeMiddleware(domain.ScopeList))
+	router.Get("/file/*", controller.GetFileHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Get("/metadata/*", controller.GetMetadataHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Delete("/file/*", controller.DeleteFileHandler, middlewares.ScopeMiddleware(domain.ScopeDelete))
+	router.Post("/file", controller.UploadFileHandler, middlewares.ScopeMiddleware(domain.ScopeWrite))
+	router.Get("/verify/*", controller.VerifyFileHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Get("/preview/*", controller.GetPreviewHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Get("/query/*", controller.QueryFileHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Get("/dedupe", controller.GetDedupeHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Get("/duplicates/*", controller.GetDuplicatesHandler, middlewares.ScopeMiddleware(domain.ScopeList))
+	router.Post("/blurhash/*", controller.BackfillBlurHashHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Post("/encryption/rotate", controller.RotateEncryptionHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Get("/quota", controller.GetQuotaHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Get("/stats", controller.GetStatsHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
 
 	return router
 }
@@ -0,0 +1,18 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"storage-api/src/application/controllers"
+	"storage-api/src/application/middlewares"
+	"storage-api/src/domain"
+)
+
+func InventoryRouter(router fiber.Router) fiber.Router {
+	controller := controllers.InventoryController()
+
+	router.Get("/inventory", controller.GetSnapshotsHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Post("/inventory", controller.ExportInventoryHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Get("/inventory/diff", controller.DiffInventoryHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+
+	return router
+}
@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"storage-api/src/application/controllers"
+	"storage-api/src/application/middlewares"
+	"storage-api/src/domain"
+)
+
+func TokenRouter(router fiber.Router) fiber.Router {
+	controller := controllers.TokenController()
+
+	router.Get("/tokens", controller.GetTokensHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Post("/tokens", controller.CreateTokenHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Post("/tokens/:id/rotate", controller.RotateTokenHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Delete("/tokens/:id", controller.RevokeTokenHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+
+	return router
+}
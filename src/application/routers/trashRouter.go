@@ -0,0 +1,17 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"storage-api/src/application/controllers"
+	"storage-api/src/application/middlewares"
+	"storage-api/src/domain"
+)
+
+func TrashRouter(router fiber.Router) fiber.Router {
+	controller := controllers.TrashController()
+
+	router.Get("/trash", controller.GetTrashHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+	router.Post("/trash/*", controller.RestoreTrashHandler, middlewares.ScopeMiddleware(domain.ScopeAdmin))
+
+	return router
+}
@@ -0,0 +1,19 @@
+package routers
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"storage-api/src/application/controllers"
+	"storage-api/src/application/middlewares"
+	"storage-api/src/domain"
+)
+
+func VersionRouter(router fiber.Router) fiber.Router {
+	controller := controllers.VersionController()
+
+	router.Get("/versions/*", controller.GetVersionsHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Delete("/versions/*", controller.PruneVersionsHandler, middlewares.ScopeMiddleware(domain.ScopeDelete))
+	router.Get("/version/:id/*", controller.GetVersionHandler, middlewares.ScopeMiddleware(domain.ScopeRead))
+	router.Post("/version/:id/*", controller.RestoreVersionHandler, middlewares.ScopeMiddleware(domain.ScopeWrite))
+
+	return router
+}
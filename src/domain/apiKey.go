@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	ScopeList   = "list"
+	ScopeRead   = "read"
+	ScopeWrite  = "write"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin"
+)
+
+var scopes = []string{ScopeList, ScopeRead, ScopeWrite, ScopeDelete, ScopeAdmin}
+
+// IApiKey is a named API key. Only the SHA-256 of the key is kept, and the key
+// is limited to its scopes and, when set, to the paths under its prefixes.
+type IApiKey struct {
+	Name     string   `json:"name"`
+	Hash     string   `json:"hash"`
+	Scopes   []string `json:"scopes"`
+	Prefixes []string `json:"prefixes"`
+}
+
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func IsScope(scope string) bool {
+	return slices.Contains(scopes, scope)
+}
+
+// ParseApiKeys reads the API keys from a JSON array.
+func ParseApiKeys(rawApiKeys string) ([]*IApiKey, error) {
+	apiKeys := make([]*IApiKey, 0)
+	if strings.TrimSpace(rawApiKeys) == "" {
+		return apiKeys, nil
+	}
+
+	if err := json.Unmarshal([]byte(rawApiKeys), &apiKeys); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(apiKeys))
+	for _, apiKey := range apiKeys {
+		if apiKey == nil || apiKey.Name == "" || names[apiKey.Name] {
+			return nil, fmt.Errorf("API keys must have a unique name")
+		}
+		names[apiKey.Name] = true
+
+		apiKey.Hash = strings.ToLower(apiKey.Hash)
+		if decoded, err := hex.DecodeString(apiKey.Hash); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash for API key '%s', it must be a hex SHA-256", apiKey.Name)
+		}
+
+		if len(apiKey.Scopes) == 0 {
+			return nil, fmt.Errorf("API key '%s' has no scopes", apiKey.Name)
+		}
+
+		for _, scope := range apiKey.Scopes {
+			if !IsScope(scope) {
+				return nil, fmt.Errorf("invalid scope '%s' for API key '%s'", scope, apiKey.Name)
+			}
+		}
+
+		prefixes, err := ParsePrefixes(apiKey.Prefixes)
+		if err != nil {
+			return nil, fmt.Errorf("%w for API key '%s'", err, apiKey.Name)
+		}
+		apiKey.Prefixes = prefixes
+	}
+
+	return apiKeys, nil
+}
+
+// ParsePrefixes returns the canonical form of the path prefixes of a key, so
+// they match the canonical paths of the requests.
+func ParsePrefixes(rawPrefixes []string) ([]string, error) {
+	prefixes := make([]string, 0, len(rawPrefixes))
+	for _, rawPrefix := range rawPrefixes {
+		key, err := ObjectKey(rawPrefix)
+		if err != nil || key.IsRoot() {
+			return nil, fmt.Errorf("invalid prefix '%s'", rawPrefix)
+		}
+
+		prefixes = append(prefixes, key.String())
+	}
+
+	return prefixes, nil
+}
+
+// FindApiKey returns the configured API key matching key, or nil.
+func FindApiKey(key string) *IApiKey {
+	hash := []byte(HashApiKey(key))
+
+	var match *IApiKey
+	for _, apiKey := range CONFIG.ApiKeys {
+		if subtle.ConstantTimeCompare(hash, []byte(apiKey.Hash)) == 1 {
+			match = apiKey
+		}
+	}
+
+	return match
+}
+
+// HasScope reports whether the key has the scope; admin keys have every scope.
+func (k *IApiKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+// AllowsPath reports whether path is under one of the key prefixes. Keys
+// without prefixes can access every path.
+func (k *IApiKey) AllowsPath(path string) bool {
+	return len(k.Prefixes) == 0 || FolderMatches(path, k.Prefixes)
+}
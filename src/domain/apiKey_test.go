@@ -0,0 +1,126 @@
+package domain
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseApiKeys(t *testing.T) {
+	hash := HashApiKey("secret")
+
+	apiKeys, err := ParseApiKeys(`[
+		{"name": "reader", "hash": "` + strings.ToUpper(hash) + `", "scopes": ["list", "read"], "prefixes": ["/docs//2024/", "images"]},
+		{"name": "admin", "hash": "` + hash + `", "scopes": ["admin"]}
+	]`)
+	if err != nil {
+		t.Fatalf("ParseApiKeys() error = %v", err)
+	}
+
+	if len(apiKeys) != 2 {
+		t.Fatalf("ParseApiKeys() returned %d keys, want 2", len(apiKeys))
+	}
+
+	reader := apiKeys[0]
+	if reader.Hash != hash {
+		t.Fatalf("hash = %s, want it in lower case", reader.Hash)
+	}
+
+	if !slices.Equal(reader.Prefixes, []string{"docs/2024", "images"}) {
+		t.Fatalf("prefixes = %v, want the canonical paths", reader.Prefixes)
+	}
+
+	for _, raw := range []string{"", "  "} {
+		if apiKeys, err = ParseApiKeys(raw); err != nil || len(apiKeys) != 0 {
+			t.Fatalf("ParseApiKeys(%q) = %v, %v, want no keys", raw, apiKeys, err)
+		}
+	}
+}
+
+func TestParseApiKeysInvalid(t *testing.T) {
+	hash := HashApiKey("secret")
+
+	tests := map[string]string{
+		"not json":       `{"name": "reader"}`,
+		"null key":       `[null]`,
+		"missing name":   `[{"hash": "` + hash + `", "scopes": ["read"]}]`,
+		"duplicate name": `[{"name": "a", "hash": "` + hash + `", "scopes": ["read"]}, {"name": "a", "hash": "` + hash + `", "scopes": ["read"]}]`,
+		"plain key":      `[{"name": "a", "hash": "secret", "scopes": ["read"]}]`,
+		"short hash":     `[{"name": "a", "hash": "` + hash[:62] + `", "scopes": ["read"]}]`,
+		"no scopes":      `[{"name": "a", "hash": "` + hash + `", "scopes": []}]`,
+		"unknown scope":  `[{"name": "a", "hash": "` + hash + `", "scopes": ["read", "root"]}]`,
+		"parent prefix":  `[{"name": "a", "hash": "` + hash + `", "scopes": ["read"], "prefixes": ["docs/../secret"]}]`,
+		"root prefix":    `[{"name": "a", "hash": "` + hash + `", "scopes": ["read"], "prefixes": ["/"]}]`,
+	}
+
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseApiKeys(raw); err == nil {
+				t.Fatal("ParseApiKeys() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestApiKeyHasScope(t *testing.T) {
+	reader := &IApiKey{Scopes: []string{ScopeList, ScopeRead}}
+	admin := &IApiKey{Scopes: []string{ScopeAdmin}}
+
+	if !reader.HasScope(ScopeRead) || reader.HasScope(ScopeWrite) || reader.HasScope(ScopeAdmin) {
+		t.Fatal("reader key scopes are not limited to list and read")
+	}
+
+	for _, scope := range scopes {
+		if !admin.HasScope(scope) {
+			t.Fatalf("admin key does not have the scope %s", scope)
+		}
+	}
+}
+
+func TestApiKeyAllowsPath(t *testing.T) {
+	limited := &IApiKey{Prefixes: []string{"docs/2024", "images"}}
+	unlimited := &IApiKey{}
+
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{path: "docs/2024", allowed: true},
+		{path: "docs/2024/report.pdf", allowed: true},
+		{path: "images/a/b.png", allowed: true},
+		{path: "docs", allowed: false},
+		{path: "docs/2025/report.pdf", allowed: false},
+		{path: "docs/2024-old/report.pdf", allowed: false},
+		{path: "imagesx/a.png", allowed: false},
+		{path: "", allowed: false},
+	}
+
+	for _, test := range tests {
+		if allowed := limited.AllowsPath(test.path); allowed != test.allowed {
+			t.Fatalf("AllowsPath(%q) = %v, want %v", test.path, allowed, test.allowed)
+		}
+
+		if !unlimited.AllowsPath(test.path) {
+			t.Fatalf("key without prefixes does not allow %q", test.path)
+		}
+	}
+}
+
+func TestFindApiKey(t *testing.T) {
+	config := CONFIG
+	t.Cleanup(func() { CONFIG = config })
+
+	apiKeys, err := ParseApiKeys(`[{"name": "reader", "hash": "` + HashApiKey("secret") + `", "scopes": ["read"]}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	CONFIG = &IConfig{ApiKeys: apiKeys}
+
+	if apiKey := FindApiKey("secret"); apiKey == nil || apiKey.Name != "reader" {
+		t.Fatalf("FindApiKey() = %v, want the reader key", apiKey)
+	}
+
+	if apiKey := FindApiKey("other"); apiKey != nil {
+		t.Fatalf("FindApiKey() = %v, want nil", apiKey)
+	}
+}
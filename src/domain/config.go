@@ -1,9 +1,12 @@
 package domain
 
 import (
+	"encoding/base64"
 	"github.com/joho/godotenv"
 	"log"
+	"net"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -11,7 +14,8 @@ import (
 type IConfig struct {
 	Port                      int
 	ApiUrl                    string
-	Token                     string
+	ApiKeys                   []*IApiKey
+	TokensDb                  string
 	CloudflareAccountId       string
 	CloudflareAccessKeyId     string
 	CloudflareSecretAccessKey string
@@ -22,6 +26,33 @@ type IConfig struct {
 	ExcludeFiles              []string
 	WhitelistIps              []string
 	BypassWhitelist           string
+	ProxyHeader               string
+	TrustedProxies            []string
+	SoftDelete                bool
+	TrashRetentionDays        int
+	Versioning                bool
+	VersionsMaxCount          int
+	VersionsMaxAgeDays        int
+	Dedupe                    bool
+	ThumbnailSizes            []string
+	StripMetadataFolders      []string
+	CompressUploads           bool
+	CompressEncoding          string
+	CompressMinSize           int
+	EncryptionFolders         []string
+	EncryptionMasterKeys      map[string][]byte
+	EncryptionActiveKey       string
+	ClamdAddress              string
+	ClamdAction               string
+	ClamdTimeout              int
+	UploadPolicies            []*IUploadPolicy
+	CollisionStrategies       map[string]string
+	KeyTemplates              map[string]string
+	Quotas                    map[string]*IQuota
+	QuotaReconcileInterval    int
+	StatsCacheTtl             int
+	InventoryInterval         int
+	InventoryFormat           string
 }
 
 func Config() *IConfig {
@@ -47,11 +78,26 @@ func Config() *IConfig {
 		log.Fatalf("Invalid API_URL value")
 	}
 
+	apiKeys, err := ParseApiKeys(os.Getenv("API_KEYS"))
+	if err != nil {
+		log.Fatalf("Invalid API_KEYS value")
+	}
+
+	// The legacy token is kept as an admin key without path restrictions.
 	token := os.Getenv("TOKEN")
-	if token == "" {
+	if token != "" {
+		apiKeys = append(apiKeys, &IApiKey{Name: "token", Hash: HashApiKey(token), Scopes: []string{ScopeAdmin}})
+	}
+
+	if len(apiKeys) == 0 {
 		log.Fatalf("Invalid TOKEN value")
 	}
 
+	tokensDb := os.Getenv("TOKENS_DB")
+	if tokensDb == "" {
+		tokensDb = "data/tokens.db"
+	}
+
 	cloudflareAccountId := os.Getenv("CLOUDFLARE_ACCOUNT_ID")
 	if cloudflareAccountId == "" {
 		log.Fatalf("Invalid CLOUDFLARE_ACCOUNT_ID value")
@@ -82,6 +128,145 @@ func Config() *IConfig {
 		whitelistIps = "127.0.0.1,::1"
 	}
 
+	thumbnailSizes := make([]string, 0)
+	for _, size := range strings.Split(os.Getenv("THUMBNAIL_SIZES"), ",") {
+		size = strings.TrimSpace(size)
+		if size == "" {
+			continue
+		}
+
+		if !regexp.MustCompile(`^[1-9][0-9]{0,3}x[1-9][0-9]{0,3}$`).MatchString(size) {
+			log.Fatalf("Invalid THUMBNAIL_SIZES value")
+		}
+
+		thumbnailSizes = append(thumbnailSizes, size)
+	}
+
+	compressEncoding := os.Getenv("COMPRESS_ENCODING")
+	if compressEncoding == "" {
+		compressEncoding = "gzip"
+	}
+
+	if compressEncoding != "gzip" && compressEncoding != "br" {
+		log.Fatalf("Invalid COMPRESS_ENCODING value")
+	}
+
+	encryptionMasterKeys := make(map[string][]byte)
+	for _, entry := range strings.Split(os.Getenv("ENCRYPTION_MASTER_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, rawKey, found := strings.Cut(entry, ":")
+		key, errKey := base64.StdEncoding.DecodeString(rawKey)
+		if !found || id == "" || errKey != nil || len(key) != 32 {
+			log.Fatalf("Invalid ENCRYPTION_MASTER_KEYS value")
+		}
+
+		encryptionMasterKeys[id] = key
+	}
+
+	encryptionActiveKey := os.Getenv("ENCRYPTION_ACTIVE_KEY")
+	if encryptionActiveKey != "" && encryptionMasterKeys[encryptionActiveKey] == nil {
+		log.Fatalf("Invalid ENCRYPTION_ACTIVE_KEY value")
+	}
+
+	encryptionFolders := make([]string, 0)
+	for _, folder := range strings.Split(os.Getenv("ENCRYPTION_FOLDERS"), ",") {
+		if folder = strings.TrimSpace(folder); folder != "" {
+			encryptionFolders = append(encryptionFolders, folder)
+		}
+	}
+
+	if len(encryptionFolders) > 0 && encryptionActiveKey == "" {
+		log.Fatalf("Invalid ENCRYPTION_ACTIVE_KEY value")
+	}
+
+	clamdAddress := os.Getenv("CLAMD_ADDRESS")
+	if clamdAddress != "" && !strings.HasPrefix(clamdAddress, "tcp://") && !strings.HasPrefix(clamdAddress, "unix://") {
+		log.Fatalf("Invalid CLAMD_ADDRESS value")
+	}
+
+	clamdAction := os.Getenv("CLAMD_ACTION")
+	if clamdAction == "" {
+		clamdAction = "reject"
+	}
+
+	if clamdAction != "reject" && clamdAction != "quarantine" {
+		log.Fatalf("Invalid CLAMD_ACTION value")
+	}
+
+	uploadPolicies, err := ParseUploadPolicies(os.Getenv("UPLOAD_POLICIES"))
+	if err != nil {
+		log.Fatalf("Invalid UPLOAD_POLICIES value")
+	}
+
+	collisionStrategies := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("COLLISION_STRATEGIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		folder, strategy, found := strings.Cut(entry, ":")
+		if !found || !regexp.MustCompile(`^(fail|overwrite|suffix|uuid)$`).MatchString(strategy) {
+			log.Fatalf("Invalid COLLISION_STRATEGIES value")
+		}
+
+		collisionStrategies[strings.Trim(folder, "/")] = strategy
+	}
+
+	keyTemplates := make(map[string]string)
+	for _, entry := range strings.Split(os.Getenv("KEY_TEMPLATES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		// The file name must keep an extension, as keys without one are not files.
+		folder, template, found := strings.Cut(entry, ":")
+		placeholders := regexp.MustCompile(`\{(yyyy|mm|dd|hh|uuid|hash|name|ext)\}`).ReplaceAllString(template, "")
+		name := template[strings.LastIndex(strings.TrimRight(template, "/"), "/")+1:]
+		if !found || strings.Trim(template, "/ ") == "" || strings.ContainsAny(placeholders, "{}") || !strings.Contains(name, ".") {
+			log.Fatalf("Invalid KEY_TEMPLATES value")
+		}
+
+		keyTemplates[strings.Trim(folder, "/")] = template
+	}
+
+	quotas, err := ParseQuotas(os.Getenv("QUOTAS"))
+	if err != nil {
+		log.Fatalf("Invalid QUOTAS value")
+	}
+
+	quotaReconcileInterval := optionalInt("QUOTA_RECONCILE_INTERVAL", 60)
+	if quotaReconcileInterval == 0 {
+		log.Fatalf("Invalid QUOTA_RECONCILE_INTERVAL value")
+	}
+
+	inventoryFormat := os.Getenv("INVENTORY_FORMAT")
+	if inventoryFormat == "" {
+		inventoryFormat = "csv"
+	}
+	if inventoryFormat != "csv" && inventoryFormat != "jsonl" {
+		log.Fatalf("Invalid INVENTORY_FORMAT value")
+	}
+
+	trustedProxies := make([]string, 0)
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+
+		if _, _, errCidr := net.ParseCIDR(proxy); errCidr != nil && net.ParseIP(proxy) == nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES value")
+		}
+
+		trustedProxies = append(trustedProxies, proxy)
+	}
+
 	if port == 0 {
 		port = tryPort
 	}
@@ -89,7 +274,8 @@ func Config() *IConfig {
 	return &IConfig{
 		Port:                      port,
 		ApiUrl:                    strings.Replace(apiUrl, "{PORT}", strconv.Itoa(port), -1),
-		Token:                     token,
+		ApiKeys:                   apiKeys,
+		TokensDb:                  tokensDb,
 		CloudflareAccountId:       cloudflareAccountId,
 		CloudflareAccessKeyId:     cloudflareAccessKeyId,
 		CloudflareSecretAccessKey: cloudflareSecretAccessKey,
@@ -100,7 +286,48 @@ func Config() *IConfig {
 		ExcludeFiles:              strings.Split(os.Getenv("EXCLUDE_FILE"), ","),
 		WhitelistIps:              strings.Split(whitelistIps, ","),
 		BypassWhitelist:           os.Getenv("BYPASS_WHITELIST"),
+		SoftDelete:                os.Getenv("SOFT_DELETE") == "true",
+		TrashRetentionDays:        optionalInt("TRASH_RETENTION_DAYS", 30),
+		Versioning:                os.Getenv("VERSIONING") == "true",
+		VersionsMaxCount:          optionalInt("VERSIONS_MAX_COUNT", 10),
+		VersionsMaxAgeDays:        optionalInt("VERSIONS_MAX_AGE_DAYS", 0),
+		Dedupe:                    os.Getenv("DEDUPE") == "true",
+		ThumbnailSizes:            thumbnailSizes,
+		StripMetadataFolders:      strings.Split(os.Getenv("STRIP_METADATA_FOLDERS"), ","),
+		CompressUploads:           os.Getenv("COMPRESS_UPLOADS") == "true",
+		CompressEncoding:          compressEncoding,
+		CompressMinSize:           optionalInt("COMPRESS_MIN_SIZE", 1024),
+		EncryptionFolders:         encryptionFolders,
+		EncryptionMasterKeys:      encryptionMasterKeys,
+		EncryptionActiveKey:       encryptionActiveKey,
+		ProxyHeader:               os.Getenv("PROXY_HEADER"),
+		TrustedProxies:            trustedProxies,
+		ClamdAddress:              clamdAddress,
+		ClamdAction:               clamdAction,
+		ClamdTimeout:              optionalInt("CLAMD_TIMEOUT", 30),
+		UploadPolicies:            uploadPolicies,
+		CollisionStrategies:       collisionStrategies,
+		KeyTemplates:              keyTemplates,
+		Quotas:                    quotas,
+		QuotaReconcileInterval:    quotaReconcileInterval,
+		StatsCacheTtl:             optionalInt("STATS_CACHE_TTL", 300),
+		InventoryInterval:         optionalInt("INVENTORY_INTERVAL", 0),
+		InventoryFormat:           inventoryFormat,
+	}
+}
+
+func optionalInt(name string, fallback int) int {
+	rawValue := os.Getenv(name)
+	if rawValue == "" {
+		return fallback
+	}
+
+	value, err := strconv.Atoi(rawValue)
+	if err != nil || value < 0 {
+		log.Fatalf("Invalid %s value", name)
 	}
+
+	return value
 }
 
 func runningInDocker() bool {
@@ -108,4 +335,6 @@ func runningInDocker() bool {
 	return err == nil
 }
 
-var CONFIG = Config()
+// CONFIG is loaded from the environment when the API starts, so the packages
+// can be tested without it.
+var CONFIG = &IConfig{}
@@ -0,0 +1,22 @@
+package domain
+
+import "strings"
+
+// FolderMatches reports whether folder is one of the given folders or is nested
+// inside one of them.
+func FolderMatches(folder string, folders []string) bool {
+	folder = strings.Trim(folder, "/")
+
+	for _, candidate := range folders {
+		candidate = strings.Trim(candidate, "/")
+		if candidate == "" {
+			continue
+		}
+
+		if folder == candidate || strings.HasPrefix(folder, candidate+"/") {
+			return true
+		}
+	}
+
+	return false
+}
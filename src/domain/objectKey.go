@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	MaxKeyLength        = 1024
+	MaxKeySegmentLength = 255
+)
+
+var ErrInvalidKey = errors.New("Invalid object key")
+
+// IObjectKey is the canonical form of a path in the bucket: segments separated
+// by a single "/", without leading or trailing slashes. The same path always
+// gives the same key, whatever slashes it was written with.
+type IObjectKey struct {
+	segments []string
+}
+
+// ObjectKey normalises raw, turning backslashes into slashes and collapsing
+// repeated ones, and rejects "." and ".." segments, control characters and
+// segments or keys that are too long. An empty path gives the root key.
+func ObjectKey(raw string) (*IObjectKey, error) {
+	if !utf8.ValidString(raw) {
+		return nil, fmt.Errorf("%w: it is not valid UTF-8", ErrInvalidKey)
+	}
+
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(strings.ReplaceAll(raw, "\\", "/"), "/") {
+		switch {
+		case segment == "":
+			continue
+		case segment == "." || segment == "..":
+			return nil, fmt.Errorf("%w: segment '%s' is not allowed", ErrInvalidKey, segment)
+		case len(segment) > MaxKeySegmentLength:
+			return nil, fmt.Errorf("%w: segment is longer than %d bytes", ErrInvalidKey, MaxKeySegmentLength)
+		case strings.IndexFunc(segment, unicode.IsControl) >= 0:
+			return nil, fmt.Errorf("%w: it contains control characters", ErrInvalidKey)
+		}
+
+		segments = append(segments, segment)
+	}
+
+	key := &IObjectKey{segments: segments}
+	if len(key.String()) > MaxKeyLength {
+		return nil, fmt.Errorf("%w: it is longer than %d bytes", ErrInvalidKey, MaxKeyLength)
+	}
+
+	return key, nil
+}
+
+// ObjectPrefix normalises a listing prefix like ObjectKey, keeping the trailing
+// slash that limits the listing to a folder.
+func ObjectPrefix(raw string) (string, error) {
+	key, err := ObjectKey(raw)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasSuffix(raw, "/") || strings.HasSuffix(raw, "\\") {
+		return key.Prefix(), nil
+	}
+
+	return key.String(), nil
+}
+
+func (k *IObjectKey) String() string {
+	return strings.Join(k.segments, "/")
+}
+
+func (k *IObjectKey) IsRoot() bool {
+	return len(k.segments) == 0
+}
+
+// Name returns the last segment of the key, or an empty string for the root.
+func (k *IObjectKey) Name() string {
+	if k.IsRoot() {
+		return ""
+	}
+
+	return k.segments[len(k.segments)-1]
+}
+
+// Folder returns the key without its last segment.
+func (k *IObjectKey) Folder() string {
+	if k.IsRoot() {
+		return ""
+	}
+
+	return strings.Join(k.segments[:len(k.segments)-1], "/")
+}
+
+// Prefix returns the key followed by "/" to list its content, or an empty
+// string for the root.
+func (k *IObjectKey) Prefix() string {
+	if k.IsRoot() {
+		return ""
+	}
+
+	return k.String() + "/"
+}
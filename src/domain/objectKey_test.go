@@ -0,0 +1,90 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		raw    string
+		key    string
+		name   string
+		folder string
+		prefix string
+	}{
+		{raw: "docs/report.pdf", key: "docs/report.pdf", name: "report.pdf", folder: "docs", prefix: "docs/report.pdf/"},
+		{raw: "/docs//2024///report.pdf/", key: "docs/2024/report.pdf", name: "report.pdf", folder: "docs/2024", prefix: "docs/2024/report.pdf/"},
+		{raw: "docs\\report.pdf", key: "docs/report.pdf", name: "report.pdf", folder: "docs", prefix: "docs/report.pdf/"},
+		{raw: "report.pdf", key: "report.pdf", name: "report.pdf", folder: "", prefix: "report.pdf/"},
+		{raw: "docs/...pdf", key: "docs/...pdf", name: "...pdf", folder: "docs", prefix: "docs/...pdf/"},
+		{raw: "", key: "", name: "", folder: "", prefix: ""},
+		{raw: "///", key: "", name: "", folder: "", prefix: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.raw, func(t *testing.T) {
+			key, err := ObjectKey(test.raw)
+			if err != nil {
+				t.Fatalf("ObjectKey() error = %v", err)
+			}
+
+			if key.String() != test.key || key.Name() != test.name || key.Folder() != test.folder || key.Prefix() != test.prefix {
+				t.Fatalf("ObjectKey() = %q, name %q, folder %q, prefix %q, want %q, %q, %q, %q",
+					key.String(), key.Name(), key.Folder(), key.Prefix(), test.key, test.name, test.folder, test.prefix)
+			}
+
+			if key.IsRoot() != (test.key == "") {
+				t.Fatalf("IsRoot() = %v for %q", key.IsRoot(), test.key)
+			}
+		})
+	}
+}
+
+func TestObjectKeyInvalid(t *testing.T) {
+	tests := map[string]string{
+		"parent":           "docs/../secret",
+		"current":          "./docs",
+		"backslash parent": "docs\\..\\secret",
+		"control":          "docs/re\x00port.pdf",
+		"newline":          "docs/report\n.pdf",
+		"invalid UTF-8":    "docs/\xff.pdf",
+		"long segment":     "docs/" + strings.Repeat("a", MaxKeySegmentLength+1),
+		"long key":         strings.Repeat(strings.Repeat("a", 100)+"/", 11),
+	}
+
+	for name, raw := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ObjectKey(raw); !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("ObjectKey() error = %v, want %v", err, ErrInvalidKey)
+			}
+		})
+	}
+}
+
+func TestObjectPrefix(t *testing.T) {
+	tests := map[string]string{
+		"":             "",
+		"/":            "",
+		"docs":         "docs",
+		"docs/":        "docs/",
+		"//docs//":     "docs/",
+		"docs\\2024\\": "docs/2024/",
+	}
+
+	for raw, want := range tests {
+		prefix, err := ObjectPrefix(raw)
+		if err != nil {
+			t.Fatalf("ObjectPrefix(%q) error = %v", raw, err)
+		}
+
+		if prefix != want {
+			t.Fatalf("ObjectPrefix(%q) = %q, want %q", raw, prefix, want)
+		}
+	}
+
+	if _, err := ObjectPrefix("docs/../"); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("ObjectPrefix() error = %v, want %v", err, ErrInvalidKey)
+	}
+}
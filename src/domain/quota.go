@@ -0,0 +1,53 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type IQuota struct {
+	Folder     string
+	MaxBytes   int64
+	MaxObjects int64
+}
+
+// ParseQuotas reads quotas written as "folder:bytes:count", separated by
+// commas. A limit of 0 leaves that dimension unlimited.
+func ParseQuotas(rawQuotas string) (map[string]*IQuota, error) {
+	quotas := make(map[string]*IQuota)
+	for _, entry := range strings.Split(rawQuotas, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid quota '%s'", entry)
+		}
+
+		folder := strings.Trim(parts[0], "/")
+		if folder == "" || strings.Contains(folder, "/") {
+			return nil, fmt.Errorf("invalid quota folder '%s', it must be a top-level folder", parts[0])
+		}
+
+		maxBytes, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || maxBytes < 0 {
+			return nil, fmt.Errorf("invalid quota size '%s'", parts[1])
+		}
+
+		maxObjects, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || maxObjects < 0 {
+			return nil, fmt.Errorf("invalid quota count '%s'", parts[2])
+		}
+
+		quotas[folder] = &IQuota{
+			Folder:     folder,
+			MaxBytes:   maxBytes,
+			MaxObjects: maxObjects,
+		}
+	}
+
+	return quotas, nil
+}
@@ -0,0 +1,117 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrPolicyFileType = errors.New("File type is not allowed")
+	ErrPolicyFileSize = errors.New("File is too large")
+	ErrPolicyFilename = errors.New("File name is not allowed")
+)
+
+type IUploadPolicy struct {
+	Folder          string   `json:"-"`
+	Extensions      []string `json:"extensions"`
+	MimeTypes       []string `json:"mimeTypes"`
+	MaxSize         int64    `json:"maxSize"`
+	MaxFiles        int      `json:"maxFiles"`
+	FilenamePattern string   `json:"filenamePattern"`
+	filenameRegexp  *regexp.Regexp
+}
+
+// ParseUploadPolicies reads the policies from a JSON object keyed by folder.
+func ParseUploadPolicies(rawPolicies string) ([]*IUploadPolicy, error) {
+	policies := make([]*IUploadPolicy, 0)
+	if strings.TrimSpace(rawPolicies) == "" {
+		return policies, nil
+	}
+
+	byFolder := make(map[string]*IUploadPolicy)
+	if err := json.Unmarshal([]byte(rawPolicies), &byFolder); err != nil {
+		return nil, err
+	}
+
+	for folder, policy := range byFolder {
+		if policy == nil || policy.MaxSize < 0 || policy.MaxFiles < 0 {
+			return nil, fmt.Errorf("invalid policy for folder '%s'", folder)
+		}
+
+		policy.Folder = strings.Trim(folder, "/")
+
+		for index, extension := range policy.Extensions {
+			policy.Extensions[index] = strings.ToLower(strings.TrimPrefix(extension, "."))
+		}
+
+		if policy.FilenamePattern != "" {
+			filenameRegexp, err := regexp.Compile(policy.FilenamePattern)
+			if err != nil {
+				return nil, err
+			}
+
+			policy.filenameRegexp = filenameRegexp
+		}
+
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// UploadPolicyFor returns the policy of the most specific folder that contains
+// folder, or nil when no policy applies.
+func UploadPolicyFor(folder string) *IUploadPolicy {
+	var match *IUploadPolicy
+	for _, policy := range CONFIG.UploadPolicies {
+		if (policy.Folder == "" || FolderMatches(folder, []string{policy.Folder})) &&
+			(match == nil || len(policy.Folder) > len(match.Folder)) {
+			match = policy
+		}
+	}
+
+	return match
+}
+
+func (p *IUploadPolicy) CheckFile(filename string, size int64) error {
+	if p.MaxSize > 0 && size > p.MaxSize {
+		return fmt.Errorf("%w in '%s': %d bytes (maximum %d)", ErrPolicyFileSize, p.Folder, size, p.MaxSize)
+	}
+
+	if len(p.Extensions) > 0 {
+		extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+		if !slices.Contains(p.Extensions, extension) {
+			return fmt.Errorf("%w in '%s': extension '%s' (allowed: %s)", ErrPolicyFileType, p.Folder, extension, strings.Join(p.Extensions, ", "))
+		}
+	}
+
+	if p.filenameRegexp != nil && !p.filenameRegexp.MatchString(filename) {
+		return fmt.Errorf("%w in '%s': it must match %s", ErrPolicyFilename, p.Folder, p.FilenamePattern)
+	}
+
+	return nil
+}
+
+// CheckContentType accepts exact MIME types and wildcards such as "image/*".
+func (p *IUploadPolicy) CheckContentType(contentType string) error {
+	if len(p.MimeTypes) == 0 {
+		return nil
+	}
+
+	contentType, _, _ = strings.Cut(contentType, ";")
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+
+	for _, allowed := range p.MimeTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == contentType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(allowed, "*"))) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w in '%s': MIME type '%s' (allowed: %s)", ErrPolicyFileType, p.Folder, contentType, strings.Join(p.MimeTypes, ", "))
+}
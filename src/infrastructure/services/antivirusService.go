@@ -0,0 +1,157 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"storage-api/src/domain"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	QuarantinePrefix      = ".quarantine/"
+	ScanStatusMetadata    = "scan-status"
+	ScanSignatureMetadata = "scan-signature"
+	ScannedAtMetadata     = "scanned-at"
+	ScanStatusClean       = "clean"
+	ScanStatusInfected    = "infected"
+	clamdChunkSize        = 64 * 1024
+)
+
+var ErrScannerUnavailable = errors.New("Antivirus scanner is not available")
+
+type IScanResult struct {
+	Infected  bool
+	Signature string
+	ScannedAt time.Time
+}
+
+func (r IScanResult) Metadata() map[string]string {
+	metadata := map[string]string{
+		ScanStatusMetadata: ScanStatusClean,
+		ScannedAtMetadata:  r.ScannedAt.UTC().Format(time.RFC3339),
+	}
+
+	if r.Infected {
+		metadata[ScanStatusMetadata] = ScanStatusInfected
+		metadata[ScanSignatureMetadata] = EncodeMetadataValue(r.Signature)
+	}
+
+	return metadata
+}
+
+// IAntivirusService scans content with a clamd-compatible daemon using the
+// INSTREAM command, over TCP or a Unix socket.
+type IAntivirusService struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func AntivirusService() *IAntivirusService {
+	network, address, _ := strings.Cut(domain.CONFIG.ClamdAddress, "://")
+
+	return &IAntivirusService{
+		network: network,
+		address: address,
+		timeout: time.Duration(domain.CONFIG.ClamdTimeout) * time.Second,
+	}
+}
+
+func (s *IAntivirusService) Enabled() bool {
+	return s.address != ""
+}
+
+func (s *IAntivirusService) Scan(data []byte) (*IScanResult, error) {
+	connection, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScannerUnavailable, err.Error())
+	}
+	defer connection.Close()
+
+	if s.timeout > 0 {
+		_ = connection.SetDeadline(time.Now().Add(s.timeout))
+	}
+
+	writer := bufio.NewWriter(connection)
+	if _, err = writer.WriteString("zINSTREAM\x00"); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScannerUnavailable, err.Error())
+	}
+
+	size := make([]byte, 4)
+	for offset := 0; offset < len(data); offset += clamdChunkSize {
+		chunk := data[offset:min(offset+clamdChunkSize, len(data))]
+
+		binary.BigEndian.PutUint32(size, uint32(len(chunk)))
+		_, _ = writer.Write(size)
+		if _, err = writer.Write(chunk); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrScannerUnavailable, err.Error())
+		}
+	}
+
+	binary.BigEndian.PutUint32(size, 0)
+	_, _ = writer.Write(size)
+	if err = writer.Flush(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScannerUnavailable, err.Error())
+	}
+
+	response, err := bufio.NewReader(connection).ReadString(0)
+	if err != nil && response == "" {
+		return nil, fmt.Errorf("%w: %s", ErrScannerUnavailable, err.Error())
+	}
+
+	return parseClamdResponse(response)
+}
+
+// Quarantine keeps an infected upload under the quarantine prefix, with its scan
+// result in the metadata, instead of publishing it. Like trash ids, the key
+// starts with the scan time so repeated uploads of a file do not replace it.
+func (s *IAntivirusService) Quarantine(storage *ICloudflareService, folder string, filename string, data []byte, result *IScanResult) (string, error) {
+	metadata := result.Metadata()
+	quarantineFolder := QuarantinePrefix + strconv.FormatInt(result.ScannedAt.UnixNano(), 10) + "/" + strings.Trim(folder, "/")
+
+	if IsEncryptedFolder(folder) {
+		encrypted, encryption, err := Encrypt(data, quarantineFolder+"/"+filename, IEncryptedContent{Checksum: ChecksumBytes(data), Size: int64(len(data))})
+		if err != nil {
+			return "", err
+		}
+
+		data = encrypted
+		for name, value := range encryption {
+			metadata[name] = value
+		}
+	}
+
+	if _, err := storage.UploadFile(bytes.NewReader(data), quarantineFolder, filename, "application/octet-stream", metadata); err != nil {
+		return "", err
+	}
+
+	return quarantineFolder + "/" + filename, nil
+}
+
+// parseClamdResponse reads replies such as "stream: OK",
+// "stream: Eicar-Signature FOUND" or "INSTREAM size limit exceeded. ERROR".
+func parseClamdResponse(response string) (*IScanResult, error) {
+	response = strings.TrimSpace(strings.TrimRight(response, "\x00"))
+	_, status, found := strings.Cut(response, ": ")
+	if !found {
+		status = response
+	}
+
+	result := &IScanResult{ScannedAt: time.Now()}
+	switch {
+	case status == "OK":
+		return result, nil
+	case strings.HasSuffix(status, " FOUND"):
+		result.Infected = true
+		result.Signature = strings.TrimSuffix(status, " FOUND")
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrScannerUnavailable, response)
+}
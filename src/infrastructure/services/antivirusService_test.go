@@ -0,0 +1,233 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClamd accepts one connection, checks the INSTREAM framing and answers
+// with reply. The received content, or the framing error, is sent to received.
+func fakeClamd(t *testing.T, reply string) (*IAntivirusService, <-chan []byte, <-chan error) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	received := make(chan []byte, 1)
+	failed := make(chan error, 1)
+
+	go func() {
+		connection, errAccept := listener.Accept()
+		if errAccept != nil {
+			failed <- errAccept
+			return
+		}
+		defer connection.Close()
+
+		content, errRead := readInstream(bufio.NewReader(connection))
+		if errRead != nil {
+			failed <- errRead
+			return
+		}
+
+		received <- content
+
+		if reply != "" {
+			_, _ = connection.Write([]byte(reply + "\x00"))
+			return
+		}
+
+		// Without a reply the client has to give up on its own.
+		_, _ = io.Copy(io.Discard, connection)
+	}()
+
+	service := &IAntivirusService{
+		network: "tcp",
+		address: listener.Addr().String(),
+		timeout: time.Second,
+	}
+
+	return service, received, failed
+}
+
+func readInstream(reader *bufio.Reader) ([]byte, error) {
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if command != "zINSTREAM\x00" {
+		return nil, fmt.Errorf("unexpected command %q", command)
+	}
+
+	var content bytes.Buffer
+	size := make([]byte, 4)
+	for {
+		if _, err = io.ReadFull(reader, size); err != nil {
+			return nil, fmt.Errorf("reading chunk length: %w", err)
+		}
+
+		length := binary.BigEndian.Uint32(size)
+		if length == 0 {
+			return content.Bytes(), nil
+		}
+
+		if length > clamdChunkSize {
+			return nil, fmt.Errorf("chunk of %d bytes is above %d", length, clamdChunkSize)
+		}
+
+		if _, err = io.CopyN(&content, reader, int64(length)); err != nil {
+			return nil, fmt.Errorf("reading chunk: %w", err)
+		}
+	}
+}
+
+func TestAntivirusScan(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*clamdChunkSize+1000)/16)
+
+	tests := []struct {
+		name      string
+		reply     string
+		infected  bool
+		signature string
+		err       error
+	}{
+		{name: "clean", reply: "stream: OK"},
+		{name: "infected", reply: "stream: Eicar-Test-Signature FOUND", infected: true, signature: "Eicar-Test-Signature"},
+		{name: "error", reply: "INSTREAM size limit exceeded. ERROR", err: ErrScannerUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service, received, failed := fakeClamd(t, test.reply)
+
+			result, err := service.Scan(data)
+
+			select {
+			case content := <-received:
+				if !bytes.Equal(content, data) {
+					t.Fatalf("clamd received %d bytes, want %d", len(content), len(data))
+				}
+			case errClamd := <-failed:
+				t.Fatalf("invalid INSTREAM request: %v", errClamd)
+			}
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("Scan() error = %v, want %v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Scan() error = %v", err)
+			}
+
+			if result.Infected != test.infected || result.Signature != test.signature {
+				t.Fatalf("Scan() = %+v, want infected %v with signature %q", result, test.infected, test.signature)
+			}
+		})
+	}
+}
+
+func TestAntivirusScanEmpty(t *testing.T) {
+	service, received, failed := fakeClamd(t, "stream: OK")
+
+	if _, err := service.Scan(nil); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+
+	select {
+	case content := <-received:
+		if len(content) != 0 {
+			t.Fatalf("clamd received %d bytes, want 0", len(content))
+		}
+	case errClamd := <-failed:
+		t.Fatalf("invalid INSTREAM request: %v", errClamd)
+	}
+}
+
+func TestAntivirusScanTimeout(t *testing.T) {
+	service, _, _ := fakeClamd(t, "")
+	service.timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	_, err := service.Scan([]byte("content"))
+
+	if !errors.Is(err, ErrScannerUnavailable) {
+		t.Fatalf("Scan() error = %v, want %v", err, ErrScannerUnavailable)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Scan() took %s, want it to stop at the timeout", elapsed)
+	}
+}
+
+func TestAntivirusScanUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	_ = listener.Close()
+
+	service := &IAntivirusService{network: "tcp", address: address, timeout: time.Second}
+
+	if _, err = service.Scan([]byte("content")); !errors.Is(err, ErrScannerUnavailable) {
+		t.Fatalf("Scan() error = %v, want %v", err, ErrScannerUnavailable)
+	}
+}
+
+func TestParseClamdResponse(t *testing.T) {
+	tests := []struct {
+		response  string
+		infected  bool
+		signature string
+		err       bool
+	}{
+		{response: "stream: OK\x00"},
+		{response: "stream: OK\n"},
+		{response: "OK"},
+		{response: "stream: Eicar-Test-Signature FOUND\x00", infected: true, signature: "Eicar-Test-Signature"},
+		{response: "stream: Win.Test.EICAR_HDB-1 FOUND", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{response: "INSTREAM size limit exceeded. ERROR\x00", err: true},
+		{response: "stream: lstat() failed: No such file or directory. ERROR", err: true},
+		{response: "", err: true},
+		{response: "UNKNOWN COMMAND", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.response, func(t *testing.T) {
+			result, err := parseClamdResponse(test.response)
+
+			if test.err {
+				if !errors.Is(err, ErrScannerUnavailable) {
+					t.Fatalf("parseClamdResponse() error = %v, want %v", err, ErrScannerUnavailable)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseClamdResponse() error = %v", err)
+			}
+
+			if result.Infected != test.infected || result.Signature != test.signature {
+				t.Fatalf("parseClamdResponse() = %+v, want infected %v with signature %q", result, test.infected, test.signature)
+			}
+
+			if result.ScannedAt.IsZero() {
+				t.Fatal("parseClamdResponse() did not set the scan time")
+			}
+		})
+	}
+}
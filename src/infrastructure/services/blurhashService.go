@@ -0,0 +1,334 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"io"
+	"math"
+	"storage-api/src/domain"
+	"strings"
+	"sync"
+)
+
+const (
+	BlurHashMetadata      = "blurhash"
+	DominantColorMetadata = "dominant-color"
+	blurHashComponentsX   = 4
+	blurHashComponentsY   = 3
+	blurHashSampleSize    = 32
+	base83Characters      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+)
+
+var ErrBackfillRunning = errors.New("Blurhash backfill is already running")
+
+// blurHashBackfills holds the prefixes with a backfill running, so that the same
+// images are not read and copied again by another one.
+var blurHashBackfills = struct {
+	sync.Mutex
+	prefixes map[string]bool
+}{prefixes: make(map[string]bool)}
+
+type IBlurHashBackfill struct {
+	Scanned int `json:"scanned"`
+	Updated int `json:"updated"`
+	Failed  int `json:"failed"`
+}
+
+type IBlurHashService struct {
+	storage *ICloudflareService
+	dedupe  *IDedupeService
+}
+
+func BlurHashService(storage *ICloudflareService) *IBlurHashService {
+	return &IBlurHashService{
+		storage: storage,
+		dedupe:  DedupeService(storage),
+	}
+}
+
+// ImagePlaceholders returns the BlurHash and dominant colour of an image as
+// object metadata, or nil when data is not a supported image.
+func ImagePlaceholders(data []byte) map[string]string {
+	img, _, err := DecodeImage(data)
+	if err != nil {
+		return nil
+	}
+
+	bounds := img.Bounds()
+	width, height := blurHashSampleSize, blurHashSampleSize
+	if bounds.Dx() > bounds.Dy() {
+		height = max(1, blurHashSampleSize*bounds.Dy()/bounds.Dx())
+	} else {
+		width = max(1, blurHashSampleSize*bounds.Dx()/bounds.Dy())
+	}
+
+	sample := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Src, nil)
+
+	return map[string]string{
+		BlurHashMetadata:      blurHash(sample, blurHashComponentsX, blurHashComponentsY),
+		DominantColorMetadata: dominantColor(sample),
+	}
+}
+
+// Backfill computes the placeholders of every image under prefix that does not
+// have them yet and stores them in its metadata.
+func (s *IBlurHashService) Backfill(prefix string) (*IBlurHashBackfill, error) {
+	objects, err := s.storage.GetAllFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	backfill := &IBlurHashBackfill{}
+	for _, object := range objects {
+		key := *object.Key
+		if hiddenPathPattern.MatchString(key) || !isImageExtension(key) {
+			continue
+		}
+
+		backfill.Scanned++
+
+		updated, errUpdate := s.backfillFile(key)
+		if errUpdate != nil {
+			backfill.Failed++
+			domain.Logger.Error("Error computing blurhash of " + key + ": " + errUpdate.Error())
+			continue
+		}
+
+		if updated {
+			backfill.Updated++
+		}
+	}
+
+	return backfill, nil
+}
+
+func (s *IBlurHashService) backfillFile(key string) (bool, error) {
+	head, err := s.storage.HeadFile(key)
+	if err != nil {
+		return false, err
+	}
+
+	if head.Metadata[BlurHashMetadata] != "" || IsEncrypted(head.Metadata) {
+		return false, nil
+	}
+
+	file, err := s.dedupe.GetFile(key)
+	if err != nil {
+		return false, err
+	}
+	defer file.Body.Close()
+
+	data, err := io.ReadAll(file.Body)
+	if err != nil {
+		return false, err
+	}
+
+	placeholders := ImagePlaceholders(data)
+	if placeholders == nil {
+		return false, ErrUnsupportedImage
+	}
+
+	_, err = s.storage.CopyFile(key, key, placeholders)
+	return err == nil, err
+}
+
+// BackfillBlurHashes runs the backfill of prefix once in the background. It
+// fails while another backfill covers part of the same files.
+func BackfillBlurHashes(prefix string) error {
+	if !startBackfill(prefix) {
+		return fmt.Errorf("%w: %s", ErrBackfillRunning, prefix)
+	}
+
+	go func() {
+		defer finishBackfill(prefix)
+
+		storage := CloudflareService()
+		if storage == nil {
+			return
+		}
+
+		backfill, err := BlurHashService(storage).Backfill(prefix)
+		if err != nil {
+			domain.Logger.Error("Error in blurhash backfill: " + err.Error())
+			return
+		}
+
+		domain.Logger.Info(fmt.Sprintf("Blurhash backfill of '%s' finished: %d scanned, %d updated, %d failed",
+			prefix, backfill.Scanned, backfill.Updated, backfill.Failed))
+	}()
+
+	return nil
+}
+
+// startBackfill marks prefix as running unless a running backfill contains it
+// or is contained in it.
+func startBackfill(prefix string) bool {
+	blurHashBackfills.Lock()
+	defer blurHashBackfills.Unlock()
+
+	for running := range blurHashBackfills.prefixes {
+		if strings.HasPrefix(prefix, running) || strings.HasPrefix(running, prefix) {
+			return false
+		}
+	}
+
+	blurHashBackfills.prefixes[prefix] = true
+	return true
+}
+
+func finishBackfill(prefix string) {
+	blurHashBackfills.Lock()
+	defer blurHashBackfills.Unlock()
+
+	delete(blurHashBackfills.prefixes, prefix)
+}
+
+func isImageExtension(key string) bool {
+	extension := strings.ToLower(key[strings.LastIndex(key, ".")+1:])
+	switch extension {
+	case "jpg", "jpeg", "png", "gif", "bmp", "webp":
+		return true
+	}
+
+	return false
+}
+
+// blurHash encodes img following the reference BlurHash algorithm.
+func blurHash(img *image.RGBA, componentsX int, componentsY int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for y := 0; y < componentsY; y++ {
+		for x := 0; x < componentsX; x++ {
+			normalisation := 2.0
+			if x == 0 && y == 0 {
+				normalisation = 1.0
+			}
+
+			var factor [3]float64
+			for py := 0; py < height; py++ {
+				for px := 0; px < width; px++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(x)*float64(px)/float64(width)) *
+						math.Cos(math.Pi*float64(y)*float64(py)/float64(height))
+
+					pixel := img.RGBAAt(px, py)
+					factor[0] += basis * sRGBToLinear(pixel.R)
+					factor[1] += basis * sRGBToLinear(pixel.G)
+					factor[2] += basis * sRGBToLinear(pixel.B)
+				}
+			}
+
+			scale := 1.0 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(base83((componentsX-1)+(componentsY-1)*9, 1))
+
+	maximum := 1.0
+	if len(factors) > 1 {
+		actualMaximum := 0.0
+		for _, factor := range factors[1:] {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximum = float64(quantisedMaximum+1) / 166
+		hash.WriteString(base83(quantisedMaximum, 1))
+	} else {
+		hash.WriteString(base83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(base83(int(linearToSRGB(dc[0]))<<16+int(linearToSRGB(dc[1]))<<8+int(linearToSRGB(dc[2])), 4))
+
+	for _, factor := range factors[1:] {
+		quantise := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximum, 0.5)*9+9.5))))
+		}
+
+		hash.WriteString(base83(quantise(factor[0])*19*19+quantise(factor[1])*19+quantise(factor[2]), 2))
+	}
+
+	return hash.String()
+}
+
+// dominantColor returns the centre of the most populated colour bucket.
+func dominantColor(img *image.RGBA) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+
+	buckets := make(map[int]*bucket)
+	var best *bucket
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			pixel := img.RGBAAt(x, y)
+			if pixel.A < 128 {
+				continue
+			}
+
+			index := int(pixel.R>>4)<<8 | int(pixel.G>>4)<<4 | int(pixel.B>>4)
+			current := buckets[index]
+			if current == nil {
+				current = &bucket{}
+				buckets[index] = current
+			}
+
+			current.count++
+			current.r += int(pixel.R)
+			current.g += int(pixel.G)
+			current.b += int(pixel.B)
+
+			if best == nil || current.count > best.count {
+				best = current
+			}
+		}
+	}
+
+	if best == nil {
+		return "#000000"
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", best.r/best.count, best.g/best.count, best.b/best.count)
+}
+
+func base83(value int, length int) string {
+	result := make([]byte, length)
+	for index := 1; index <= length; index++ {
+		digit := (value / int(math.Pow(83, float64(length-index)))) % 83
+		result[index-1] = base83Characters[digit]
+	}
+
+	return string(result)
+}
+
+func sRGBToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) float64 {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return math.Trunc(v*12.92*255 + 0.5)
+	}
+
+	return math.Trunc((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value float64, exponent float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exponent), value)
+}
@@ -0,0 +1,40 @@
+package services
+
+import "testing"
+
+func TestStartBackfill(t *testing.T) {
+	if !startBackfill("images/2024/") {
+		t.Fatal("startBackfill() = false with no backfill running")
+	}
+	t.Cleanup(func() { finishBackfill("images/2024/") })
+
+	tests := []struct {
+		prefix  string
+		started bool
+	}{
+		{prefix: "images/2024/", started: false},
+		{prefix: "images/2024/01/", started: false},
+		{prefix: "images/", started: false},
+		{prefix: "", started: false},
+		{prefix: "images/2025/", started: true},
+		{prefix: "docs/", started: true},
+	}
+
+	for _, test := range tests {
+		started := startBackfill(test.prefix)
+		if started {
+			finishBackfill(test.prefix)
+		}
+
+		if started != test.started {
+			t.Fatalf("startBackfill(%q) = %v, want %v", test.prefix, started, test.started)
+		}
+	}
+
+	finishBackfill("images/2024/")
+
+	if !startBackfill("images/") {
+		t.Fatal("startBackfill() = false after the backfill finished")
+	}
+	finishBackfill("images/")
+}
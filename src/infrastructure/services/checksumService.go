@@ -0,0 +1,106 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+)
+
+const ChecksumMetadata = "sha256"
+
+var ErrChecksumMismatch = errors.New("Checksum mismatch")
+
+type IChecksumVerification struct {
+	Filename string `json:"filename"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Valid    bool   `json:"valid"`
+}
+
+type IChecksumService struct {
+	dedupe *IDedupeService
+}
+
+func ChecksumService(storage *ICloudflareService) *IChecksumService {
+	return &IChecksumService{
+		dedupe: DedupeService(storage),
+	}
+}
+
+func Checksum(reader io.Reader) (string, error) {
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// ReadWithChecksum reads the whole content and computes its digest while it is
+// being read, instead of in a second pass over the buffer. When expected is not
+// empty, content with another digest is rejected.
+func ReadWithChecksum(reader io.Reader, expected string) ([]byte, string, error) {
+	hasher := sha256.New()
+
+	data, err := io.ReadAll(io.TeeReader(reader, hasher))
+	if err != nil {
+		return nil, "", err
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if expected != "" && !ChecksumMatches(expected, checksum) {
+		return nil, "", ErrChecksumMismatch
+	}
+
+	return data, checksum, nil
+}
+
+func ChecksumBytes(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+func ChecksumMatches(expected string, actual string) bool {
+	return strings.EqualFold(strings.TrimSpace(expected), actual)
+}
+
+// StoredChecksum returns the checksum of the content uploaded to key. Encrypted
+// objects keep it sealed with their data key instead of in the plain metadata.
+func StoredChecksum(key string, metadata map[string]string) string {
+	if !IsEncrypted(metadata) {
+		return metadata[ChecksumMetadata]
+	}
+
+	content, err := EncryptedContent(key, metadata)
+	if err != nil {
+		return ""
+	}
+
+	return content.Checksum
+}
+
+// Verify reads the whole object again and compares its digest with the one
+// stored in its metadata when it was uploaded.
+func (s *IChecksumService) Verify(filename string) (*IChecksumVerification, error) {
+	file, err := s.dedupe.GetFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Body.Close()
+
+	actual, err := Checksum(file.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	expected := StoredChecksum(filename, file.Metadata)
+
+	return &IChecksumVerification{
+		Filename: filename,
+		Expected: expected,
+		Actual:   actual,
+		Valid:    expected != "" && ChecksumMatches(expected, actual),
+	}, nil
+}
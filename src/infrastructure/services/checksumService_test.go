@@ -0,0 +1,99 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestReadWithChecksum(t *testing.T) {
+	content := []byte(strings.Repeat("storage", 10000))
+	checksum := ChecksumBytes(content)
+
+	tests := []struct {
+		name     string
+		expected string
+		err      error
+	}{
+		{name: "no checksum"},
+		{name: "matching checksum", expected: strings.ToUpper(checksum)},
+		{name: "other checksum", expected: ChecksumBytes([]byte("other")), err: ErrChecksumMismatch},
+		{name: "truncated checksum", expected: checksum[:32], err: ErrChecksumMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, actual, err := ReadWithChecksum(bytes.NewReader(content), test.expected)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("ReadWithChecksum() error = %v, want %v", err, test.err)
+			}
+
+			if test.err != nil {
+				if data != nil {
+					t.Fatal("ReadWithChecksum() returned the rejected content")
+				}
+				return
+			}
+
+			if !bytes.Equal(data, content) || actual != checksum {
+				t.Fatalf("ReadWithChecksum() = %d bytes with %s, want %d with %s", len(data), actual, len(content), checksum)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	content := []byte("quarterly report")
+	checksum := ChecksumBytes(content)
+
+	storage := testBucket(t, map[string]testObject{
+		"docs/valid.txt":     {body: content, metadata: map[string]string{ChecksumMetadata: checksum}},
+		"docs/corrupted.txt": {body: []byte("quarterly rep0rt"), metadata: map[string]string{ChecksumMetadata: checksum}},
+		"docs/legacy.txt":    {body: content},
+	})
+
+	tests := []struct {
+		filename string
+		expected string
+		valid    bool
+	}{
+		{filename: "docs/valid.txt", expected: checksum, valid: true},
+		{filename: "docs/corrupted.txt", expected: checksum, valid: false},
+		{filename: "docs/legacy.txt", expected: "", valid: false},
+	}
+
+	for _, test := range tests {
+		verification, err := ChecksumService(storage).Verify(test.filename)
+		if err != nil {
+			t.Fatalf("Verify(%s) error = %v", test.filename, err)
+		}
+
+		if verification.Expected != test.expected || verification.Valid != test.valid {
+			t.Fatalf("Verify(%s) = %+v, want expected %q and valid %v", test.filename, verification, test.expected, test.valid)
+		}
+	}
+
+	if _, err := ChecksumService(storage).Verify("docs/missing.txt"); !errors.Is(err, ErrFileNotExist) {
+		t.Fatalf("Verify() of a missing file error = %v, want %v", err, ErrFileNotExist)
+	}
+}
+
+func TestChecksumMatches(t *testing.T) {
+	// SHA-256 of "abc".
+	actual := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+
+	if ChecksumBytes([]byte("abc")) != actual {
+		t.Fatalf("ChecksumBytes() = %s, want %s", ChecksumBytes([]byte("abc")), actual)
+	}
+
+	for _, expected := range []string{actual, strings.ToUpper(actual), " " + actual + "\n"} {
+		if !ChecksumMatches(expected, actual) {
+			t.Fatalf("ChecksumMatches(%q) = false, want true", expected)
+		}
+	}
+
+	if ChecksumMatches(actual[:63]+"0", actual) {
+		t.Fatal("ChecksumMatches() = true for a different digest")
+	}
+}
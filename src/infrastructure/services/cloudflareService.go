@@ -2,24 +2,45 @@ package services
 
 import (
 	"context"
+	"crypto/md5"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/aws/smithy-go"
 	"io"
+	"net/http"
+	"net/url"
 	"storage-api/src/domain"
 	"time"
 )
 
+var (
+	ErrFileNotExist        = errors.New("File is not exist")
+	ErrFileAlreadyExists   = errors.New("File already exists")
+	ErrInvalidCustomerKey  = errors.New("Encryption key must be a base64 encoded 256-bit key")
+	ErrCustomerKeyRequired = errors.New("File is encrypted with a customer key, the encryption key is required")
+	ErrCustomerKeyMismatch = errors.New("Encryption key does not match the key the file was stored with")
+)
+
+// ICustomerKey is an SSE-C key: R2 encrypts the object with it and does not
+// keep it, so the same key has to be sent to read or copy the object.
+type ICustomerKey struct {
+	Algorithm string
+	Key       string
+	KeyMD5    string
+}
+
 type ICloudflareService struct {
-	Client     *r2.Client
-	BucketName string
-	Context    context.Context
+	Client      *r2.Client
+	BucketName  string
+	Context     context.Context
+	customerKey *ICustomerKey
 }
 
 func CloudflareService() *ICloudflareService {
@@ -48,9 +69,42 @@ func CloudflareService() *ICloudflareService {
 	}
 }
 
+func CustomerKey(rawKey string) (*ICustomerKey, error) {
+	key, err := base64.StdEncoding.DecodeString(rawKey)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidCustomerKey
+	}
+
+	checksum := md5.Sum(key)
+
+	return &ICustomerKey{
+		Algorithm: "AES256",
+		Key:       rawKey,
+		KeyMD5:    base64.StdEncoding.EncodeToString(checksum[:]),
+	}, nil
+}
+
+// WithCustomerKey returns a copy of the service that sends the SSE-C key on
+// every read, write and copy.
+func (s *ICloudflareService) WithCustomerKey(key *ICustomerKey) *ICloudflareService {
+	scoped := *s
+	scoped.customerKey = key
+
+	return &scoped
+}
+
+func (s *ICloudflareService) HasCustomerKey() bool {
+	return s.customerKey != nil
+}
+
 func (s *ICloudflareService) GetFiles(folder string) ([]types.Object, error) {
+	prefix, err := domain.ObjectPrefix(folder)
+	if err != nil {
+		return nil, err
+	}
+
 	input := &r2.ListObjectsV2Input{
-		Prefix: &folder,
+		Prefix: &prefix,
 		Bucket: &s.BucketName,
 	}
 
@@ -61,39 +115,224 @@ func (s *ICloudflareService) GetFiles(folder string) ([]types.Object, error) {
 	return resp.Contents, nil
 }
 
-func (s *ICloudflareService) GetFile(base64Filename string) (*r2.GetObjectOutput, error) {
-	filenameEncoded := base64.StdEncoding.EncodeToString([]byte(base64Filename))
-	filename, _ := base64.StdEncoding.DecodeString(filenameEncoded)
+func (s *ICloudflareService) GetAllFiles(rawPrefix string) ([]types.Object, error) {
+	prefix, err := domain.ObjectPrefix(rawPrefix)
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := s.Client.GetObject(s.Context, &r2.GetObjectInput{
+	paginator := r2.NewListObjectsV2Paginator(s.Client, &r2.ListObjectsV2Input{
+		Prefix: &prefix,
 		Bucket: &s.BucketName,
-		Key:    aws.String(string(filename)),
 	})
+
+	objects := make([]types.Object, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(s.Context)
+		if err != nil {
+			return nil, err
+		}
+
+		objects = append(objects, page.Contents...)
+	}
+
+	return objects, nil
+}
+
+func (s *ICloudflareService) GetFile(filename string) (*r2.GetObjectOutput, error) {
+	key, err := objectKey(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &r2.GetObjectInput{
+		Bucket: &s.BucketName,
+		Key:    key,
+	}
+	if s.customerKey != nil {
+		input.SSECustomerAlgorithm = &s.customerKey.Algorithm
+		input.SSECustomerKey = &s.customerKey.Key
+		input.SSECustomerKeyMD5 = &s.customerKey.KeyMD5
+	}
+
+	resp, err := s.Client.GetObject(s.Context, input)
 	if err != nil {
 		var awsErr *types.NoSuchKey
 		if errors.As(err, &awsErr) {
-			return nil, fmt.Errorf("File is not exist")
+			return nil, ErrFileNotExist
 		}
 
 		var smithyErr smithy.APIError
 		if errors.As(err, &smithyErr) && smithyErr.ErrorCode() == "NoSuchKey" {
-			return nil, fmt.Errorf("File is not exist")
+			return nil, ErrFileNotExist
+		}
+
+		return nil, s.customerKeyError(err)
+	}
+
+	return resp, nil
+}
+
+func (s *ICloudflareService) GetFileRange(filename string, byteRange string) (*r2.GetObjectOutput, error) {
+	key, err := objectKey(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &r2.GetObjectInput{
+		Bucket: &s.BucketName,
+		Key:    key,
+		Range:  aws.String(byteRange),
+	}
+	if s.customerKey != nil {
+		input.SSECustomerAlgorithm = &s.customerKey.Algorithm
+		input.SSECustomerKey = &s.customerKey.Key
+		input.SSECustomerKeyMD5 = &s.customerKey.KeyMD5
+	}
+
+	resp, err := s.Client.GetObject(s.Context, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrFileNotExist
+		}
+
+		return nil, s.customerKeyError(err)
+	}
+
+	return resp, nil
+}
+
+func (s *ICloudflareService) HeadFile(filename string) (*r2.HeadObjectOutput, error) {
+	input, err := s.headInput(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Client.HeadObject(s.Context, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrFileNotExist
+		}
+
+		return nil, s.customerKeyError(err)
+	}
+
+	return resp, nil
+}
+
+func (s *ICloudflareService) FileExists(filename string) (bool, error) {
+	input, err := s.headInput(filename)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = s.Client.HeadObject(s.Context, input)
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
 		}
 
+		return false, s.customerKeyError(err)
+	}
+
+	return true, nil
+}
+
+// CopyFile copies an object inside the bucket. When metadata is not nil the
+// source metadata is replaced by the merge of both maps; empty values remove a key.
+// Data keys of encrypted objects are wrapped again for the destination key.
+func (s *ICloudflareService) CopyFile(source string, destination string, metadata map[string]string) (*r2.CopyObjectOutput, error) {
+	sourceKey, err := objectKey(source)
+	if err != nil {
+		return nil, err
+	}
+
+	destinationKey, err := objectKey(destination)
+	if err != nil {
 		return nil, err
 	}
 
+	input := &r2.CopyObjectInput{
+		Bucket:     &s.BucketName,
+		CopySource: aws.String((&url.URL{Path: s.BucketName + "/" + *sourceKey}).EscapedPath()),
+		Key:        destinationKey,
+	}
+	if s.customerKey != nil {
+		input.CopySourceSSECustomerAlgorithm = &s.customerKey.Algorithm
+		input.CopySourceSSECustomerKey = &s.customerKey.Key
+		input.CopySourceSSECustomerKeyMD5 = &s.customerKey.KeyMD5
+		input.SSECustomerAlgorithm = &s.customerKey.Algorithm
+		input.SSECustomerKey = &s.customerKey.Key
+		input.SSECustomerKeyMD5 = &s.customerKey.KeyMD5
+	}
+
+	// Encrypted objects have their data key bound to the object key, so a copy to
+	// another key reads the source to wrap it again for the destination.
+	var head *r2.HeadObjectOutput
+	if metadata != nil || *sourceKey != *destinationKey {
+		head, err = s.HeadFile(*sourceKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rewrap := *sourceKey != *destinationKey && IsEncrypted(head.Metadata)
+	if metadata != nil || rewrap {
+		merged := make(map[string]string, len(head.Metadata)+len(metadata))
+		for key, value := range head.Metadata {
+			merged[key] = value
+		}
+		for key, value := range metadata {
+			if value == "" {
+				delete(merged, key)
+				continue
+			}
+			merged[key] = value
+		}
+
+		if rewrap {
+			if err = rewrapKey(merged, *sourceKey, *destinationKey); err != nil {
+				return nil, err
+			}
+		}
+
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.ContentType = head.ContentType
+		input.Metadata = merged
+	}
+
+	resp, err := s.Client.CopyObject(s.Context, input)
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrFileNotExist
+		}
+
+		return nil, s.customerKeyError(err)
+	}
+
 	return resp, nil
 }
 
-func (s *ICloudflareService) UploadFile(fileReader io.Reader, folderName string, filename string, contentType string) (*r2.PutObjectOutput, error) {
-	filePath := folderName + "/" + filename
-	resp, err := s.Client.PutObject(s.Context, &r2.PutObjectInput{
+func (s *ICloudflareService) UploadFile(fileReader io.Reader, folderName string, filename string, contentType string, metadata map[string]string) (*r2.PutObjectOutput, error) {
+	key, err := objectKey(folderName + "/" + filename)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &r2.PutObjectInput{
 		Bucket:      &s.BucketName,
-		Key:         aws.String(filePath),
+		Key:         key,
 		Body:        fileReader,
 		ContentType: aws.String(contentType),
-	})
+		Metadata:    metadata,
+	}
+	if s.customerKey != nil {
+		input.SSECustomerAlgorithm = &s.customerKey.Algorithm
+		input.SSECustomerKey = &s.customerKey.Key
+		input.SSECustomerKeyMD5 = &s.customerKey.KeyMD5
+	}
+
+	resp, err := s.Client.PutObject(s.Context, input)
 	if err != nil {
 		return nil, err
 	}
@@ -101,9 +340,14 @@ func (s *ICloudflareService) UploadFile(fileReader io.Reader, folderName string,
 }
 
 func (s *ICloudflareService) DeleteFile(filename string) (*r2.DeleteObjectOutput, error) {
+	key, err := objectKey(filename)
+	if err != nil {
+		return nil, err
+	}
+
 	resp, err := s.Client.DeleteObject(s.Context, &r2.DeleteObjectInput{
 		Bucket: &s.BucketName,
-		Key:    aws.String(filename),
+		Key:    key,
 	})
 	if err != nil {
 		return nil, err
@@ -112,10 +356,15 @@ func (s *ICloudflareService) DeleteFile(filename string) (*r2.DeleteObjectOutput
 }
 
 func (s *ICloudflareService) GenerateSignedURL(filename string) (string, error) {
+	key, err := objectKey(filename)
+	if err != nil {
+		return "", err
+	}
+
 	resignClient := r2.NewPresignClient(s.Client)
 	input := &r2.GetObjectInput{
 		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(filename),
+		Key:    key,
 	}
 	resp, err := resignClient.PresignGetObject(s.Context, input, r2.WithPresignExpires(1*time.Hour))
 	if err != nil {
@@ -123,3 +372,76 @@ func (s *ICloudflareService) GenerateSignedURL(filename string) (string, error)
 	}
 	return resp.URL, nil
 }
+
+func (s *ICloudflareService) headInput(filename string) (*r2.HeadObjectInput, error) {
+	key, err := objectKey(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &r2.HeadObjectInput{
+		Bucket: &s.BucketName,
+		Key:    key,
+	}
+	if s.customerKey != nil {
+		input.SSECustomerAlgorithm = &s.customerKey.Algorithm
+		input.SSECustomerKey = &s.customerKey.Key
+		input.SSECustomerKeyMD5 = &s.customerKey.KeyMD5
+	}
+
+	return input, nil
+}
+
+// objectKey returns the canonical key of an object, so every method stores and
+// reads the same object for the same path.
+func objectKey(filename string) (*string, error) {
+	key, err := domain.ObjectKey(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.IsRoot() {
+		return nil, fmt.Errorf("%w: it is empty", domain.ErrInvalidKey)
+	}
+
+	return aws.String(key.String()), nil
+}
+
+// customerKeyError translates the errors R2 returns for SSE-C objects: a bad
+// request when the key is missing and a forbidden one when it does not match.
+// HEAD responses carry no error code, so only the status can be checked.
+func (s *ICloudflareService) customerKeyError(err error) error {
+	var responseErr *awshttp.ResponseError
+	if !errors.As(err, &responseErr) {
+		return err
+	}
+
+	switch status := responseErr.HTTPStatusCode(); {
+	case s.customerKey == nil && status == http.StatusBadRequest:
+		return ErrCustomerKeyRequired
+	case s.customerKey != nil && (status == http.StatusBadRequest || status == http.StatusForbidden):
+		return ErrCustomerKeyMismatch
+	}
+
+	return err
+}
+
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+
+	var notFound *types.NotFound
+	if errors.As(err, &notFound) {
+		return true
+	}
+
+	var smithyErr smithy.APIError
+	if errors.As(err, &smithyErr) {
+		code := smithyErr.ErrorCode()
+		return code == "NoSuchKey" || code == "NotFound"
+	}
+
+	return false
+}
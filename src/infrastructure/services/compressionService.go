@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	ContentEncodingMetadata = "content-encoding"
+	OriginalSizeMetadata    = "original-size"
+	EncodingGzip            = "gzip"
+	EncodingBrotli          = "br"
+)
+
+var compressibleContentTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/x-ndjson",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+var compressibleExtensions = map[string]bool{
+	".csv":    true,
+	".tsv":    true,
+	".jsonl":  true,
+	".ndjson": true,
+	".log":    true,
+	".md":     true,
+	".yaml":   true,
+	".yml":    true,
+	".toml":   true,
+	".map":    true,
+}
+
+// IsCompressible reports whether the content is text-like and worth storing
+// compressed, based on the file extension and, as a fallback, on its bytes.
+func IsCompressible(filename string, data []byte) bool {
+	extension := strings.ToLower(filepath.Ext(filename))
+	if compressibleExtensions[extension] {
+		return true
+	}
+
+	contentType := mime.TypeByExtension(extension)
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+
+	contentType, _, _ = strings.Cut(contentType, ";")
+	if strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+
+	for _, compressible := range compressibleContentTypes {
+		if contentType == compressible {
+			return true
+		}
+	}
+
+	return false
+}
+
+func Compress(data []byte, encoding string) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	var writer io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		writer, _ = gzip.NewWriterLevel(&buffer, gzip.BestCompression)
+	case EncodingBrotli:
+		writer = brotli.NewWriterLevel(&buffer, brotli.BestCompression)
+	default:
+		return nil, fmt.Errorf("Unsupported content encoding: %s", encoding)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func Decompress(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+
+		return readCloser{Reader: reader, closer: body}, nil
+	case EncodingBrotli:
+		return readCloser{Reader: brotli.NewReader(body), closer: body}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported content encoding: %s", encoding)
+	}
+}
+
+// DecodeFile replaces the body of a compressed object with its decompressed
+// content. Objects without encoding are left as they are.
+func DecodeFile(file *r2.GetObjectOutput) error {
+	encoding := file.Metadata[ContentEncodingMetadata]
+	if encoding == "" {
+		return nil
+	}
+
+	body, err := Decompress(file.Body, encoding)
+	if err != nil {
+		_ = file.Body.Close()
+		return err
+	}
+
+	file.Body = body
+	file.ContentLength = nil
+	if size, errSize := strconv.ParseInt(file.Metadata[OriginalSizeMetadata], 10, 64); errSize == nil {
+		file.ContentLength = &size
+	}
+
+	return nil
+}
+
+// OriginalSize returns the size of the content uploaded to key when the object
+// holds something else: a dedupe reference, or compressed or encrypted content.
+// Otherwise it returns size.
+func OriginalSize(key string, size int64, metadata map[string]string) int64 {
+	if blobSize, err := strconv.ParseInt(metadata[BlobSizeMetadata], 10, 64); err == nil {
+		size = blobSize
+	}
+
+	if originalSize, err := strconv.ParseInt(metadata[OriginalSizeMetadata], 10, 64); err == nil {
+		size = originalSize
+	}
+
+	if IsEncrypted(metadata) {
+		if content, err := EncryptedContent(key, metadata); err == nil {
+			size = content.Size
+		}
+	}
+
+	return size
+}
+
+// AcceptsEncoding reports whether an Accept-Encoding header allows the encoding.
+func AcceptsEncoding(header string, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, parameters, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encoding && name != "*" {
+			continue
+		}
+
+		quality := 1.0
+		if rawQuality, found := strings.CutPrefix(strings.TrimSpace(parameters), "q="); found {
+			if value, err := strconv.ParseFloat(rawQuality, 64); err == nil {
+				quality = value
+			}
+		}
+
+		// An explicit entry for the encoding takes precedence over the wildcard.
+		if name == encoding {
+			return quality > 0
+		}
+
+		accepted = quality > 0
+	}
+
+	return accepted
+}
+
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.closer.Close()
+}
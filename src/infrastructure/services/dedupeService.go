@@ -0,0 +1,399 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"storage-api/src/domain"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	BlobsPrefix      = ".blobs/"
+	RefsPrefix       = ".refs/"
+	BlobMetadata     = "blob"
+	BlobSizeMetadata = "blob-size"
+)
+
+var referenceLocks [256]sync.Mutex
+
+type IDedupeReport struct {
+	Blobs        int   `json:"blobs"`
+	References   int   `json:"references"`
+	StoredBytes  int64 `json:"storedBytes"`
+	LogicalBytes int64 `json:"logicalBytes"`
+	SavedBytes   int64 `json:"savedBytes"`
+}
+
+// IDedupeService wraps the storage operations that have to be aware of
+// content-addressed references: every object holding a reference to a blob has
+// a marker under the refs prefix, and the blob is removed with its last marker.
+type IDedupeService struct {
+	storage *ICloudflareService
+}
+
+func DedupeService(storage *ICloudflareService) *IDedupeService {
+	return &IDedupeService{
+		storage: storage,
+	}
+}
+
+// UploadFile stores the content under folder/filename. In dedupe mode the
+// content is stored once under its checksum and the key becomes a reference.
+func (s *IDedupeService) UploadFile(fileReader io.Reader, size int64, folderName string, filename string, contentType string, metadata map[string]string) error {
+	key := folderName + "/" + filename
+	hash := metadata[ChecksumMetadata]
+
+	// Compressed content is kept apart from the plain one, as the bytes differ.
+	if encoding := metadata[ContentEncodingMetadata]; hash != "" && encoding != "" {
+		hash += "." + encoding
+	}
+
+	previous, err := s.reference(key)
+	if err != nil {
+		return err
+	}
+
+	// Encrypted content is sealed with its own key, so it cannot be shared.
+	if domain.CONFIG.Dedupe && hash != "" && !IsEncrypted(metadata) && !s.storage.HasCustomerKey() {
+		err = s.storeBlob(fileReader, size, folderName, filename, contentType, metadata, hash)
+	} else {
+		_, err = s.storage.UploadFile(fileReader, folderName, filename, contentType, metadata)
+		hash = ""
+	}
+	if err != nil {
+		return err
+	}
+
+	if previous != hash {
+		return s.release(key, previous)
+	}
+
+	return nil
+}
+
+// GetFile returns the object content, following the reference to its blob,
+// decrypting it and decompressing it when it is stored compressed.
+func (s *IDedupeService) GetFile(filename string) (*r2.GetObjectOutput, error) {
+	file, err := s.GetEncodedFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = DecodeFile(file); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// GetEncodedFile returns the object content decrypted but still in the content
+// encoding it is stored with.
+func (s *IDedupeService) GetEncodedFile(filename string) (*r2.GetObjectOutput, error) {
+	file, err := s.getStoredFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = DecryptFile(file, filename); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+func (s *IDedupeService) getStoredFile(filename string) (*r2.GetObjectOutput, error) {
+	file, err := s.storage.GetFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := file.Metadata[BlobMetadata]
+	if hash == "" {
+		return file, nil
+	}
+
+	_ = file.Body.Close()
+
+	blob, err := s.storage.GetFile(BlobsPrefix + hash)
+	if err != nil {
+		return nil, err
+	}
+
+	blob.ContentType = file.ContentType
+	blob.Metadata = file.Metadata
+	blob.LastModified = file.LastModified
+
+	return blob, nil
+}
+
+// GetFileRange returns a byte range of the object content, following the
+// reference to its blob, together with the total size of the content.
+func (s *IDedupeService) GetFileRange(filename string, byteRange string) (*r2.GetObjectOutput, int64, error) {
+	head, err := s.storage.HeadFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if head.Metadata[ContentEncodingMetadata] != "" || IsEncrypted(head.Metadata) {
+		return s.getDecodedRange(filename, byteRange, head)
+	}
+
+	key := filename
+	size := aws.ToInt64(head.ContentLength)
+	if hash := head.Metadata[BlobMetadata]; hash != "" {
+		key = BlobsPrefix + hash
+		size, _ = strconv.ParseInt(head.Metadata[BlobSizeMetadata], 10, 64)
+	}
+
+	if size == 0 {
+		return nil, 0, nil
+	}
+
+	file, err := s.storage.GetFileRange(key, byteRange)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file.ContentType = head.ContentType
+	file.Metadata = head.Metadata
+
+	return file, size, nil
+}
+
+// getDecodedRange serves a range of a compressed or encrypted object, which can
+// only be read from the start, by skipping the decoded bytes before it.
+func (s *IDedupeService) getDecodedRange(filename string, byteRange string, head *r2.HeadObjectOutput) (*r2.GetObjectOutput, int64, error) {
+	size := OriginalSize(filename, -1, head.Metadata)
+	if size < 0 {
+		return nil, 0, fmt.Errorf("Original size of %s is unknown", filename)
+	}
+	if size == 0 {
+		return nil, 0, nil
+	}
+
+	start, end, err := parseByteRange(byteRange, size)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	file, err := s.GetFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if _, err = io.CopyN(io.Discard, file.Body, start); err != nil {
+		_ = file.Body.Close()
+		return nil, 0, err
+	}
+
+	length := end - start + 1
+	file.Body = readCloser{Reader: io.LimitReader(file.Body, length), closer: file.Body}
+	file.ContentLength = &length
+
+	return file, size, nil
+}
+
+func (s *IDedupeService) CopyFile(source string, destination string, metadata map[string]string) error {
+	hash, err := s.reference(source)
+	if err != nil {
+		return err
+	}
+
+	previous, err := s.reference(destination)
+	if err != nil {
+		return err
+	}
+
+	if hash != "" {
+		lock := referenceLock(hash)
+		lock.Lock()
+		err = s.addReference(destination, hash)
+		lock.Unlock()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err = s.storage.CopyFile(source, destination, metadata); err != nil {
+		return err
+	}
+
+	if previous != hash {
+		return s.release(destination, previous)
+	}
+
+	return nil
+}
+
+func (s *IDedupeService) DeleteFile(filename string) error {
+	hash, err := s.reference(filename)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.storage.DeleteFile(filename); err != nil {
+		return err
+	}
+
+	return s.release(filename, hash)
+}
+
+func (s *IDedupeService) Report() (*IDedupeReport, error) {
+	blobs, err := s.storage.GetAllFiles(BlobsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := s.storage.GetAllFiles(RefsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make(map[string]int64, len(blobs))
+	report := &IDedupeReport{}
+	for _, blob := range blobs {
+		sizes[strings.TrimPrefix(*blob.Key, BlobsPrefix)] = *blob.Size
+		report.Blobs++
+		report.StoredBytes += *blob.Size
+	}
+
+	for _, ref := range refs {
+		hash, _, found := strings.Cut(strings.TrimPrefix(*ref.Key, RefsPrefix), "/")
+		if !found {
+			continue
+		}
+
+		report.References++
+		report.LogicalBytes += sizes[hash]
+	}
+
+	report.SavedBytes = report.LogicalBytes - report.StoredBytes
+	if report.SavedBytes < 0 {
+		report.SavedBytes = 0
+	}
+
+	return report, nil
+}
+
+func (s *IDedupeService) storeBlob(fileReader io.Reader, size int64, folderName string, filename string, contentType string, metadata map[string]string, hash string) error {
+	lock := referenceLock(hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := s.addReference(folderName+"/"+filename, hash); err != nil {
+		return err
+	}
+
+	exists, err := s.storage.FileExists(BlobsPrefix + hash)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if _, err = s.storage.UploadFile(fileReader, strings.TrimSuffix(BlobsPrefix, "/"), hash, contentType, nil); err != nil {
+			return err
+		}
+	}
+
+	referenceMetadata := make(map[string]string, len(metadata)+2)
+	for name, value := range metadata {
+		referenceMetadata[name] = value
+	}
+	referenceMetadata[BlobMetadata] = hash
+	referenceMetadata[BlobSizeMetadata] = strconv.FormatInt(size, 10)
+
+	_, err = s.storage.UploadFile(bytes.NewReader(nil), folderName, filename, contentType, referenceMetadata)
+	return err
+}
+
+func (s *IDedupeService) reference(filename string) (string, error) {
+	head, err := s.storage.HeadFile(filename)
+	if err != nil {
+		if errors.Is(err, ErrFileNotExist) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return head.Metadata[BlobMetadata], nil
+}
+
+func (s *IDedupeService) addReference(holder string, hash string) error {
+	_, err := s.storage.UploadFile(bytes.NewReader(nil), RefsPrefix+hash, holder, "application/octet-stream", nil)
+	return err
+}
+
+func (s *IDedupeService) release(holder string, hash string) error {
+	if hash == "" {
+		return nil
+	}
+
+	lock := referenceLock(hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := s.storage.DeleteFile(RefsPrefix + hash + "/" + holder); err != nil {
+		return err
+	}
+
+	remaining, err := s.storage.GetFiles(RefsPrefix + hash + "/")
+	if err != nil {
+		return err
+	}
+
+	if len(remaining) > 0 {
+		return nil
+	}
+
+	_, err = s.storage.DeleteFile(BlobsPrefix + hash)
+	return err
+}
+
+// parseByteRange resolves a single "bytes=start-end", "bytes=start-" or
+// "bytes=-suffix" range against the content size.
+func parseByteRange(byteRange string, size int64) (int64, int64, error) {
+	rawStart, rawEnd, found := strings.Cut(strings.TrimPrefix(byteRange, "bytes="), "-")
+	if !found {
+		return 0, 0, errors.New("Invalid byte range: " + byteRange)
+	}
+
+	if rawStart == "" {
+		suffix, err := strconv.ParseInt(rawEnd, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, errors.New("Invalid byte range: " + byteRange)
+		}
+
+		return max(0, size-suffix), size - 1, nil
+	}
+
+	start, err := strconv.ParseInt(rawStart, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, errors.New("Invalid byte range: " + byteRange)
+	}
+
+	end := size - 1
+	if rawEnd != "" {
+		if end, err = strconv.ParseInt(rawEnd, 10, 64); err != nil || end < start {
+			return 0, 0, errors.New("Invalid byte range: " + byteRange)
+		}
+	}
+
+	return start, min(end, size-1), nil
+}
+
+func referenceLock(hash string) *sync.Mutex {
+	index, err := strconv.ParseUint(hash[:min(2, len(hash))], 16, 8)
+	if err != nil {
+		index = 0
+	}
+
+	return &referenceLocks[index]
+}
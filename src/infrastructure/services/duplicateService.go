@@ -0,0 +1,159 @@
+package services
+
+import (
+	"regexp"
+	"sort"
+	"storage-api/src/domain"
+	"strings"
+)
+
+var hiddenPathPattern = regexp.MustCompile(`(^|/)\.`)
+
+type IDuplicateGroup struct {
+	Size             int64    `json:"size"`
+	Hash             string   `json:"hash"`
+	Keys             []string `json:"keys"`
+	ReclaimableBytes int64    `json:"reclaimableBytes"`
+}
+
+type IDuplicateReport struct {
+	ScannedFiles     int               `json:"scannedFiles"`
+	SkippedFiles     int               `json:"skippedFiles"`
+	Groups           []IDuplicateGroup `json:"groups"`
+	ReclaimableBytes int64             `json:"reclaimableBytes"`
+}
+
+type IDuplicateService struct {
+	storage *ICloudflareService
+	dedupe  *IDedupeService
+}
+
+func DuplicateService(storage *ICloudflareService) *IDuplicateService {
+	return &IDuplicateService{
+		storage: storage,
+		dedupe:  DedupeService(storage),
+	}
+}
+
+// FindDuplicates groups the files under prefix by size and then by ETag. When
+// computeHash is true, or an ETag of the group is not a plain MD5 (multipart or
+// SSE-C uploads), the whole group is compared with the SHA-256 of the content
+// instead. Files that cannot be read are skipped.
+func (s *IDuplicateService) FindDuplicates(prefix string, computeHash bool) (*IDuplicateReport, error) {
+	objects, err := s.storage.GetAllFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IDuplicateReport{
+		Groups: make([]IDuplicateGroup, 0),
+	}
+
+	bySize := make(map[int64][]string)
+	etags := make(map[string]string, len(objects))
+	for _, object := range objects {
+		if *object.Size == 0 || hiddenPathPattern.MatchString(*object.Key) {
+			continue
+		}
+
+		report.ScannedFiles++
+		bySize[*object.Size] = append(bySize[*object.Size], *object.Key)
+		if object.ETag != nil {
+			etags[*object.Key] = strings.Trim(*object.ETag, `"`)
+		}
+	}
+
+	for size, keys := range bySize {
+		if len(keys) < 2 {
+			continue
+		}
+
+		hashes := s.groupHashes(keys, etags, computeHash)
+		report.SkippedFiles += len(keys) - len(hashes)
+
+		byHash := make(map[string][]string)
+		for key, hash := range hashes {
+			byHash[hash] = append(byHash[hash], key)
+		}
+
+		for hash, duplicates := range byHash {
+			if len(duplicates) < 2 {
+				continue
+			}
+
+			sort.Strings(duplicates)
+
+			reclaimable := size * int64(len(duplicates)-1)
+			report.Groups = append(report.Groups, IDuplicateGroup{
+				Size:             size,
+				Hash:             hash,
+				Keys:             duplicates,
+				ReclaimableBytes: reclaimable,
+			})
+			report.ReclaimableBytes += reclaimable
+		}
+	}
+
+	sort.Slice(report.Groups, func(i, j int) bool {
+		return report.Groups[i].ReclaimableBytes > report.Groups[j].ReclaimableBytes
+	})
+
+	return report, nil
+}
+
+// groupHashes returns the hash of each file of a size group. Every file of the
+// group is hashed the same way, so that a file uploaded in parts is compared
+// with the same content uploaded at once. Files that cannot be read are logged
+// and left out.
+func (s *IDuplicateService) groupHashes(keys []string, etags map[string]string, computeHash bool) map[string]string {
+	hashes := make(map[string]string, len(keys))
+
+	if !computeHash {
+		for _, key := range keys {
+			etag := etags[key]
+			if etag == "" || strings.Contains(etag, "-") {
+				clear(hashes)
+				break
+			}
+
+			hashes[key] = etag
+		}
+
+		if len(hashes) == len(keys) {
+			return hashes
+		}
+	}
+
+	for _, key := range keys {
+		hash, err := s.contentHash(key)
+		if err != nil {
+			domain.Logger.Error("Error hashing " + key + " to find duplicates: " + err.Error())
+			continue
+		}
+
+		hashes[key] = hash
+	}
+
+	return hashes
+}
+
+// contentHash returns the SHA-256 stored when key was uploaded, or computes it
+// from the content of older files.
+func (s *IDuplicateService) contentHash(key string) (string, error) {
+	head, err := s.storage.HeadFile(key)
+	if err != nil {
+		return "", err
+	}
+
+	if checksum := StoredChecksum(key, head.Metadata); checksum != "" {
+		return checksum, nil
+	}
+
+	file, err := s.dedupe.GetFile(key)
+	if err != nil {
+		return "", err
+	}
+	defer file.Body.Close()
+
+	return Checksum(file.Body)
+}
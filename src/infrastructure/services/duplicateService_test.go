@@ -0,0 +1,86 @@
+package services
+
+import (
+	"net/http"
+	"path/filepath"
+	"slices"
+	"storage-api/src/domain"
+	"testing"
+)
+
+// withLogger writes the logs of the test to a temporary file.
+func withLogger(t *testing.T) {
+	t.Helper()
+
+	logger := domain.Logger
+	if err := domain.CustomLogger(filepath.Join(t.TempDir(), "app.log")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		domain.Logger.Close()
+		domain.Logger = logger
+	})
+}
+
+func TestFindDuplicates(t *testing.T) {
+	withLogger(t)
+
+	content := []byte("quarterly report")
+	checksum := ChecksumBytes(content)
+
+	storage := testBucket(t, map[string]testObject{
+		"docs/a.txt":           {body: content},
+		"docs/b.txt":           {body: content},
+		"docs/multipart.txt":   {body: content, etag: "0123456789abcdef0123456789abcdef-2", metadata: map[string]string{ChecksumMetadata: checksum}},
+		"docs/other.txt":       {body: []byte("quarterly rep0rt")},
+		"docs/private.pdf":     {body: []byte("customer key"), etag: "fedcba9876543210fedcba9876543210", status: http.StatusBadRequest},
+		"docs/private-2.pdf":   {body: []byte("customer key")},
+		"docs/single.txt":      {body: []byte("single")},
+		"docs/.hidden/a.txt":   {body: content},
+		"docs/empty-reference": {},
+	})
+
+	tests := []struct {
+		name    string
+		compute bool
+	}{
+		{name: "etags"},
+		{name: "computed hashes", compute: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := DuplicateService(storage).FindDuplicates("docs/", test.compute)
+			if err != nil {
+				t.Fatalf("FindDuplicates() error = %v", err)
+			}
+
+			if report.ScannedFiles != 7 {
+				t.Fatalf("scanned %d files, want 7", report.ScannedFiles)
+			}
+
+			if len(report.Groups) != 1 {
+				t.Fatalf("FindDuplicates() = %+v, want one group", report.Groups)
+			}
+
+			group := report.Groups[0]
+			if group.Hash != checksum || !slices.Equal(group.Keys, []string{"docs/a.txt", "docs/b.txt", "docs/multipart.txt"}) {
+				t.Fatalf("group = %+v, want the copies of the report by their SHA-256", group)
+			}
+
+			if group.ReclaimableBytes != 2*int64(len(content)) || report.ReclaimableBytes != group.ReclaimableBytes {
+				t.Fatalf("reclaimable = %d and %d bytes, want %d", group.ReclaimableBytes, report.ReclaimableBytes, 2*len(content))
+			}
+		})
+	}
+
+	report, err := DuplicateService(storage).FindDuplicates("docs/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.SkippedFiles != 1 {
+		t.Fatalf("skipped %d files, want the file that cannot be read", report.SkippedFiles)
+	}
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"math"
+	"storage-api/src/domain"
+)
+
+const (
+	EncryptionKeyMetadata     = "encryption-key"
+	EncryptionKeyIdMetadata   = "encryption-key-id"
+	EncryptionNonceMetadata   = "encryption-nonce"
+	EncryptionContentMetadata = "encryption-content"
+	encryptionChunkSize       = 64 * 1024
+	encryptionNonceSize       = 8
+)
+
+var (
+	ErrDecryptionFailed = errors.New("File could not be decrypted")
+
+	contentAad = []byte("content")
+)
+
+// IEncryptedContent describes the plain content of an encrypted object. It is
+// sealed with the data key, as its checksum would identify the content.
+type IEncryptedContent struct {
+	Checksum string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+type IEncryptionRotation struct {
+	Scanned   int `json:"scanned"`
+	Rewrapped int `json:"rewrapped"`
+	Failed    int `json:"failed"`
+}
+
+type IEncryptionService struct {
+	storage *ICloudflareService
+}
+
+func EncryptionService(storage *ICloudflareService) *IEncryptionService {
+	return &IEncryptionService{
+		storage: storage,
+	}
+}
+
+func IsEncryptedFolder(folder string) bool {
+	return domain.CONFIG.EncryptionActiveKey != "" && domain.FolderMatches(folder, domain.CONFIG.EncryptionFolders)
+}
+
+func IsEncrypted(metadata map[string]string) bool {
+	return metadata[EncryptionKeyMetadata] != ""
+}
+
+// Encrypt seals data with a new random data key using AES-GCM and returns the
+// ciphertext together with the metadata holding the data key wrapped by the
+// active master key for the object key, and the sealed description of the plain
+// content. The content is sealed in chunks so it can be decrypted while it is
+// streamed.
+func Encrypt(data []byte, key string, content IEncryptedContent) ([]byte, map[string]string, error) {
+	dataKey := make([]byte, 32)
+	nonce := make([]byte, encryptionNonceSize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := newGcm(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrappedKey, err := wrapKey(dataKey, domain.CONFIG.EncryptionActiveKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawContent, err := json.Marshal(content)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chunks := max(1, (len(data)+encryptionChunkSize-1)/encryptionChunkSize)
+	ciphertext := make([]byte, 0, len(data)+chunks*aead.Overhead())
+	for index := 0; index < chunks; index++ {
+		chunk := data[index*encryptionChunkSize : min((index+1)*encryptionChunkSize, len(data))]
+		ciphertext = aead.Seal(ciphertext, chunkNonce(nonce, index), chunk, chunkAad(index == chunks-1))
+	}
+
+	return ciphertext, map[string]string{
+		EncryptionKeyMetadata:     wrappedKey,
+		EncryptionKeyIdMetadata:   domain.CONFIG.EncryptionActiveKey,
+		EncryptionNonceMetadata:   base64.RawURLEncoding.EncodeToString(nonce),
+		EncryptionContentMetadata: base64.RawURLEncoding.EncodeToString(aead.Seal(nil, contentNonce(nonce), rawContent, contentAad)),
+	}, nil
+}
+
+// EncryptedContent opens the description of the plain content of the encrypted
+// object stored under key.
+func EncryptedContent(key string, metadata map[string]string) (*IEncryptedContent, error) {
+	dataKey, err := unwrapKey(metadata[EncryptionKeyMetadata], metadata[EncryptionKeyIdMetadata], key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.RawURLEncoding.DecodeString(metadata[EncryptionNonceMetadata])
+	if err != nil || len(nonce) != encryptionNonceSize {
+		return nil, ErrDecryptionFailed
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(metadata[EncryptionContentMetadata])
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	aead, err := newGcm(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	rawContent, err := aead.Open(nil, contentNonce(nonce), sealed, contentAad)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	var content IEncryptedContent
+	if err = json.Unmarshal(rawContent, &content); err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return &content, nil
+}
+
+// DecryptFile replaces the body of the encrypted object stored under key with a
+// reader that decrypts it. Objects without encryption are left as they are.
+func DecryptFile(file *r2.GetObjectOutput, key string) error {
+	if !IsEncrypted(file.Metadata) {
+		return nil
+	}
+
+	dataKey, err := unwrapKey(file.Metadata[EncryptionKeyMetadata], file.Metadata[EncryptionKeyIdMetadata], key)
+	if err != nil {
+		_ = file.Body.Close()
+		return err
+	}
+
+	nonce, err := base64.RawURLEncoding.DecodeString(file.Metadata[EncryptionNonceMetadata])
+	if err != nil || len(nonce) != encryptionNonceSize {
+		_ = file.Body.Close()
+		return ErrDecryptionFailed
+	}
+
+	aead, err := newGcm(dataKey)
+	if err != nil {
+		_ = file.Body.Close()
+		return err
+	}
+
+	file.Body = readCloser{
+		Reader: &decryptReader{source: bufio.NewReaderSize(file.Body, encryptionChunkSize+aead.Overhead()+1), aead: aead, nonce: nonce},
+		closer: file.Body,
+	}
+	file.ContentLength = nil
+
+	return nil
+}
+
+// Rotate re-wraps with the active master key the data keys of every encrypted
+// object under prefix, including trash and versions. The content is not
+// re-encrypted, so the old master keys can be removed once it finishes.
+func (s *IEncryptionService) Rotate(prefix string) (*IEncryptionRotation, error) {
+	objects, err := s.storage.GetAllFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	rotation := &IEncryptionRotation{}
+	for _, object := range objects {
+		key := *object.Key
+
+		head, errHead := s.storage.HeadFile(key)
+		if errHead != nil {
+			rotation.Failed++
+			domain.Logger.Error("Error reading " + key + ": " + errHead.Error())
+			continue
+		}
+
+		if !IsEncrypted(head.Metadata) {
+			continue
+		}
+
+		rotation.Scanned++
+
+		if head.Metadata[EncryptionKeyIdMetadata] == domain.CONFIG.EncryptionActiveKey {
+			continue
+		}
+
+		dataKey, errKey := unwrapKey(head.Metadata[EncryptionKeyMetadata], head.Metadata[EncryptionKeyIdMetadata], key)
+		if errKey != nil {
+			rotation.Failed++
+			domain.Logger.Error("Error unwrapping data key of " + key + ": " + errKey.Error())
+			continue
+		}
+
+		wrappedKey, errKey := wrapKey(dataKey, domain.CONFIG.EncryptionActiveKey, key)
+		if errKey != nil {
+			rotation.Failed++
+			domain.Logger.Error("Error wrapping data key of " + key + ": " + errKey.Error())
+			continue
+		}
+
+		_, errCopy := s.storage.CopyFile(key, key, map[string]string{
+			EncryptionKeyMetadata:   wrappedKey,
+			EncryptionKeyIdMetadata: domain.CONFIG.EncryptionActiveKey,
+		})
+		if errCopy != nil {
+			rotation.Failed++
+			domain.Logger.Error("Error updating data key of " + key + ": " + errCopy.Error())
+			continue
+		}
+
+		rotation.Rewrapped++
+	}
+
+	return rotation, nil
+}
+
+// RotateEncryptionKeys runs the rotation of prefix once in the background.
+func RotateEncryptionKeys(prefix string) {
+	go func() {
+		storage := CloudflareService()
+		if storage == nil {
+			return
+		}
+
+		rotation, err := EncryptionService(storage).Rotate(prefix)
+		if err != nil {
+			domain.Logger.Error("Error in encryption key rotation: " + err.Error())
+			return
+		}
+
+		domain.Logger.Info(fmt.Sprintf("Encryption key rotation of '%s' finished: %d scanned, %d rewrapped, %d failed",
+			prefix, rotation.Scanned, rotation.Rewrapped, rotation.Failed))
+	}()
+}
+
+// rewrapKey wraps the data key of an encrypted object copied from source to
+// destination for its new key, with the active master key when there is one.
+func rewrapKey(metadata map[string]string, source string, destination string) error {
+	dataKey, err := unwrapKey(metadata[EncryptionKeyMetadata], metadata[EncryptionKeyIdMetadata], source)
+	if err != nil {
+		return err
+	}
+
+	keyId := domain.CONFIG.EncryptionActiveKey
+	if keyId == "" {
+		keyId = metadata[EncryptionKeyIdMetadata]
+	}
+
+	wrappedKey, err := wrapKey(dataKey, keyId, destination)
+	if err != nil {
+		return err
+	}
+
+	metadata[EncryptionKeyMetadata] = wrappedKey
+	metadata[EncryptionKeyIdMetadata] = keyId
+
+	return nil
+}
+
+// wrapKey seals a data key with a master key. The key id and the object key are
+// authenticated, so a wrapped key cannot be presented as belonging to another
+// master key or moved onto another object.
+func wrapKey(dataKey []byte, keyId string, key string) (string, error) {
+	masterKey := domain.CONFIG.EncryptionMasterKeys[keyId]
+	if masterKey == nil {
+		return "", fmt.Errorf("Encryption master key '%s' is not configured", keyId)
+	}
+
+	aad, err := wrapAad(keyId, key)
+	if err != nil {
+		return "", err
+	}
+
+	aead, err := newGcm(masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, dataKey, aad)), nil
+}
+
+func unwrapKey(wrappedKey string, keyId string, key string) ([]byte, error) {
+	masterKey := domain.CONFIG.EncryptionMasterKeys[keyId]
+	if masterKey == nil {
+		return nil, fmt.Errorf("Encryption master key '%s' is not configured", keyId)
+	}
+
+	aad, err := wrapAad(keyId, key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGcm(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(wrappedKey)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+
+	dataKey, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return dataKey, nil
+}
+
+// wrapAad joins the master key id and the canonical object key; the key id
+// cannot contain the separator, as the configuration splits on it.
+func wrapAad(keyId string, key string) ([]byte, error) {
+	objectKey, err := domain.ObjectKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(keyId + ":" + objectKey.String()), nil
+}
+
+func newGcm(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(prefix []byte, index int) []byte {
+	nonce := make([]byte, encryptionNonceSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNonceSize:], uint32(index))
+
+	return nonce
+}
+
+// contentNonce is the nonce of the content description, after every possible
+// chunk nonce.
+func contentNonce(prefix []byte) []byte {
+	return chunkNonce(prefix, math.MaxUint32)
+}
+
+// chunkAad marks the last chunk, so a truncated ciphertext fails to decrypt.
+func chunkAad(last bool) []byte {
+	if last {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
+
+type decryptReader struct {
+	source  *bufio.Reader
+	aead    cipher.AEAD
+	nonce   []byte
+	index   int
+	pending []byte
+	done    bool
+}
+
+func (r *decryptReader) Read(buffer []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+
+		chunk := make([]byte, encryptionChunkSize+r.aead.Overhead())
+		read, err := io.ReadFull(r.source, chunk)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return 0, ErrDecryptionFailed
+			}
+			return 0, err
+		}
+
+		_, errPeek := r.source.Peek(1)
+		last := errPeek == io.EOF
+
+		plaintext, err := r.aead.Open(chunk[:0], chunkNonce(r.nonce, r.index), chunk[:read], chunkAad(last))
+		if err != nil {
+			return 0, ErrDecryptionFailed
+		}
+
+		r.index++
+		r.done = last
+		r.pending = plaintext
+	}
+
+	read := copy(buffer, r.pending)
+	r.pending = r.pending[read:]
+
+	return read, nil
+}
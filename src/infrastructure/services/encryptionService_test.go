@@ -0,0 +1,194 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"io"
+	"storage-api/src/domain"
+	"strings"
+	"testing"
+)
+
+const testEncryptedKey = "private/report.pdf"
+
+// withMasterKeys configures two master keys, with the first one active, for the
+// duration of the test.
+func withMasterKeys(t *testing.T) {
+	t.Helper()
+
+	config := domain.CONFIG
+	domain.CONFIG = &domain.IConfig{
+		EncryptionMasterKeys: map[string][]byte{
+			"first":  bytes.Repeat([]byte{1}, 32),
+			"second": bytes.Repeat([]byte{2}, 32),
+		},
+		EncryptionActiveKey: "first",
+	}
+	t.Cleanup(func() { domain.CONFIG = config })
+}
+
+func encryptForTest(t *testing.T, data []byte) ([]byte, map[string]string) {
+	t.Helper()
+
+	ciphertext, metadata, err := Encrypt(data, testEncryptedKey, IEncryptedContent{Checksum: ChecksumBytes(data), Size: int64(len(data))})
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	return ciphertext, metadata
+}
+
+func decryptForTest(ciphertext []byte, metadata map[string]string, key string) ([]byte, error) {
+	file := &r2.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(ciphertext)), Metadata: metadata}
+	if err := DecryptFile(file, key); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(file.Body)
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	withMasterKeys(t)
+
+	tests := map[string]int{
+		"empty":          0,
+		"small":          10,
+		"one chunk":      encryptionChunkSize,
+		"several chunks": 2*encryptionChunkSize + 5,
+	}
+
+	for name, size := range tests {
+		t.Run(name, func(t *testing.T) {
+			data := []byte(strings.Repeat("x", size))
+			ciphertext, metadata := encryptForTest(t, data)
+
+			if size > 0 && bytes.Contains(ciphertext, data[:min(size, 64)]) {
+				t.Fatal("ciphertext contains the plain content")
+			}
+
+			decrypted, err := decryptForTest(ciphertext, metadata, testEncryptedKey)
+			if err != nil {
+				t.Fatalf("decrypt error = %v", err)
+			}
+
+			if !bytes.Equal(decrypted, data) {
+				t.Fatalf("decrypted %d bytes, want %d", len(decrypted), len(data))
+			}
+		})
+	}
+}
+
+func TestEncryptSealsContent(t *testing.T) {
+	withMasterKeys(t)
+
+	data := []byte("confidential")
+	_, metadata := encryptForTest(t, data)
+
+	for name, value := range metadata {
+		if strings.Contains(value, ChecksumBytes(data)) || name == ChecksumMetadata || name == OriginalSizeMetadata {
+			t.Fatalf("metadata %s exposes the plain content", name)
+		}
+	}
+
+	content, err := EncryptedContent(testEncryptedKey, metadata)
+	if err != nil {
+		t.Fatalf("EncryptedContent() error = %v", err)
+	}
+
+	if content.Checksum != ChecksumBytes(data) || content.Size != int64(len(data)) {
+		t.Fatalf("EncryptedContent() = %+v, want the checksum and size of the content", content)
+	}
+
+	if checksum := StoredChecksum(testEncryptedKey, metadata); checksum != ChecksumBytes(data) {
+		t.Fatalf("StoredChecksum() = %s, want %s", checksum, ChecksumBytes(data))
+	}
+
+	if size := OriginalSize(testEncryptedKey, 100, metadata); size != int64(len(data)) {
+		t.Fatalf("OriginalSize() = %d, want %d", size, len(data))
+	}
+}
+
+func TestDecryptRejectsTamperedContent(t *testing.T) {
+	withMasterKeys(t)
+
+	data := []byte(strings.Repeat("abc", encryptionChunkSize))
+	ciphertext, metadata := encryptForTest(t, data)
+	chunk := encryptionChunkSize + 16
+
+	reordered := append(append(append([]byte{}, ciphertext[chunk:2*chunk]...), ciphertext[:chunk]...), ciphertext[2*chunk:]...)
+	flipped := append([]byte{}, ciphertext...)
+	flipped[10] ^= 1
+
+	tests := map[string][]byte{
+		"truncated to whole chunks": ciphertext[:2*chunk],
+		"truncated inside a chunk":  ciphertext[:len(ciphertext)-1],
+		"empty":                     nil,
+		"reordered chunks":          reordered,
+		"flipped bit":               flipped,
+	}
+
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := decryptForTest(tampered, metadata, testEncryptedKey); !errors.Is(err, ErrDecryptionFailed) {
+				t.Fatalf("decrypt error = %v, want %v", err, ErrDecryptionFailed)
+			}
+		})
+	}
+}
+
+func TestWrappedKeyIsBoundToObjectKey(t *testing.T) {
+	withMasterKeys(t)
+
+	data := []byte("confidential")
+	ciphertext, metadata := encryptForTest(t, data)
+
+	if _, err := decryptForTest(ciphertext, metadata, "public/report.pdf"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("decrypt under another key error = %v, want %v", err, ErrDecryptionFailed)
+	}
+
+	if _, err := EncryptedContent("public/report.pdf", metadata); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("EncryptedContent() under another key error = %v, want %v", err, ErrDecryptionFailed)
+	}
+
+	// The same key written differently is still the same object.
+	if _, err := decryptForTest(ciphertext, metadata, "/private//report.pdf"); err != nil {
+		t.Fatalf("decrypt under the same key error = %v", err)
+	}
+
+	moved := map[string]string{}
+	for name, value := range metadata {
+		moved[name] = value
+	}
+
+	domain.CONFIG.EncryptionActiveKey = "second"
+	if err := rewrapKey(moved, testEncryptedKey, "archive/report.pdf"); err != nil {
+		t.Fatalf("rewrapKey() error = %v", err)
+	}
+
+	if moved[EncryptionKeyIdMetadata] != "second" {
+		t.Fatalf("rewrapped key id = %s, want the active key", moved[EncryptionKeyIdMetadata])
+	}
+
+	decrypted, err := decryptForTest(ciphertext, moved, "archive/report.pdf")
+	if err != nil || !bytes.Equal(decrypted, data) {
+		t.Fatalf("decrypt after rewrapKey() = %q, %v, want the content", decrypted, err)
+	}
+}
+
+func TestWrappedKeyIsBoundToMasterKey(t *testing.T) {
+	withMasterKeys(t)
+
+	ciphertext, metadata := encryptForTest(t, []byte("confidential"))
+
+	// Claim the key was wrapped by the other master key.
+	metadata[EncryptionKeyIdMetadata] = "second"
+	if _, err := decryptForTest(ciphertext, metadata, testEncryptedKey); !errors.Is(err, ErrDecryptionFailed) {
+		t.Fatalf("decrypt error = %v, want %v", err, ErrDecryptionFailed)
+	}
+
+	metadata[EncryptionKeyIdMetadata] = "removed"
+	if _, err := decryptForTest(ciphertext, metadata, testEncryptedKey); err == nil {
+		t.Fatal("decrypt with a master key that is not configured succeeded")
+	}
+}
@@ -0,0 +1,353 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+const (
+	exifTagOrientation = 0x0112
+	exifTagExifIFD     = 0x8769
+)
+
+var (
+	jpegSignature = []byte{0xFF, 0xD8}
+	pngSignature  = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1A, '\n'}
+	exifHeader    = []byte("Exif\x00\x00")
+
+	errInvalidExif = errors.New("Invalid EXIF data")
+)
+
+// Chunks that only carry metadata and can be dropped without touching the pixels.
+var pngMetadataChunks = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+type IExifValue struct {
+	order  binary.ByteOrder
+	kind   uint16
+	values []byte
+}
+
+func (v IExifValue) Int() (int64, bool) {
+	switch {
+	case v.kind == 3 && len(v.values) >= 2:
+		return int64(v.order.Uint16(v.values)), true
+	case (v.kind == 4 || v.kind == 9) && len(v.values) >= 4:
+		if v.kind == 9 {
+			return int64(int32(v.order.Uint32(v.values))), true
+		}
+		return int64(v.order.Uint32(v.values)), true
+	case v.kind == 1 && len(v.values) >= 1:
+		return int64(v.values[0]), true
+	}
+
+	return 0, false
+}
+
+func (v IExifValue) String() string {
+	if v.kind != 2 {
+		return ""
+	}
+
+	return string(bytes.TrimRight(v.values, "\x00 "))
+}
+
+// StripImageMetadata removes EXIF, XMP, IPTC and comment data from JPEG and PNG
+// files by dropping whole segments or chunks, so the image is not re-encoded.
+// With autoRotate a JPEG whose orientation tag is not the default is rotated
+// first, which does require decoding and re-encoding it, so it is subject to
+// the same size limit as the other image transformations.
+func StripImageMetadata(data []byte, autoRotate bool) ([]byte, bool, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		return stripPng(data)
+	}
+
+	if !bytes.HasPrefix(data, jpegSignature) {
+		return data, false, nil
+	}
+
+	if autoRotate {
+		if orientation := JpegOrientation(data); orientation > 1 && orientation <= 8 {
+			img, _, err := DecodeImage(data)
+			if err != nil {
+				return nil, false, err
+			}
+
+			var buffer bytes.Buffer
+			if err = jpeg.Encode(&buffer, orientImage(img, orientation), &jpeg.Options{Quality: 95}); err != nil {
+				return nil, false, err
+			}
+
+			return buffer.Bytes(), true, nil
+		}
+	}
+
+	return stripJpeg(data)
+}
+
+// JpegOrientation returns the EXIF orientation tag of a JPEG, or 0 when missing.
+func JpegOrientation(data []byte) int {
+	tags, err := JpegExif(data)
+	if err != nil {
+		return 0
+	}
+
+	orientation, ok := tags[exifTagOrientation].Int()
+	if !ok {
+		return 0
+	}
+
+	return int(orientation)
+}
+
+// JpegExif returns the tags of IFD0 and the EXIF sub-IFD of a JPEG.
+func JpegExif(data []byte) (map[uint16]IExifValue, error) {
+	var tags map[uint16]IExifValue
+
+	_, err := walkJpeg(data, func(marker byte, segment []byte) bool {
+		if marker == 0xE1 && bytes.HasPrefix(segment, exifHeader) {
+			tags, _ = parseTiff(segment[len(exifHeader):])
+			return false
+		}
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if tags == nil {
+		return nil, errInvalidExif
+	}
+
+	return tags, nil
+}
+
+func stripJpeg(data []byte) ([]byte, bool, error) {
+	output := bytes.NewBuffer(make([]byte, 0, len(data)))
+	output.Write(jpegSignature)
+
+	changed := false
+	imageData, err := walkJpeg(data, func(marker byte, segment []byte) bool {
+		// APP0 (JFIF), APP2 (ICC profile) and APP14 (Adobe colour transform) affect
+		// how the image is rendered; the rest of APPn and COM are plain metadata.
+		if (marker >= 0xE1 && marker <= 0xEF && marker != 0xE2 && marker != 0xEE) || marker == 0xFE {
+			changed = true
+			return true
+		}
+
+		output.Write([]byte{0xFF, marker})
+		_ = binary.Write(output, binary.BigEndian, uint16(len(segment)+2))
+		output.Write(segment)
+
+		return true
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	output.Write(data[imageData:])
+
+	return output.Bytes(), changed, nil
+}
+
+func stripPng(data []byte) ([]byte, bool, error) {
+	output := bytes.NewBuffer(make([]byte, 0, len(data)))
+	output.Write(pngSignature)
+
+	changed := false
+	offset := len(pngSignature)
+
+	for offset+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[offset:]))
+		end := offset + 12 + length
+		if end > len(data) {
+			return nil, false, errors.New("Invalid PNG chunk")
+		}
+
+		if pngMetadataChunks[string(data[offset+4:offset+8])] {
+			changed = true
+		} else {
+			output.Write(data[offset:end])
+		}
+
+		offset = end
+	}
+
+	return output.Bytes(), changed, nil
+}
+
+// walkJpeg calls visit with every marker segment before the image data until it
+// returns false, and returns the offset where the image data starts.
+func walkJpeg(data []byte, visit func(marker byte, segment []byte) bool) (int, error) {
+	if !bytes.HasPrefix(data, jpegSignature) {
+		return 0, errors.New("Invalid JPEG file")
+	}
+
+	offset := len(jpegSignature)
+	for offset+4 <= len(data) {
+		if data[offset] != 0xFF {
+			return 0, errors.New("Invalid JPEG segment")
+		}
+
+		marker := data[offset+1]
+		if marker == 0xFF {
+			offset++
+			continue
+		}
+
+		if marker == 0xDA {
+			return offset, nil
+		}
+
+		length := int(binary.BigEndian.Uint16(data[offset+2:]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return 0, errors.New("Invalid JPEG segment")
+		}
+
+		if !visit(marker, data[offset+4:end]) {
+			return offset, nil
+		}
+
+		offset = end
+	}
+
+	return 0, errors.New("Invalid JPEG file")
+}
+
+func parseTiff(data []byte) (map[uint16]IExifValue, error) {
+	if len(data) < 8 {
+		return nil, errInvalidExif
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errInvalidExif
+	}
+
+	tags := make(map[uint16]IExifValue)
+	if err := parseIfd(data, order, order.Uint32(data[4:]), tags); err != nil {
+		return nil, err
+	}
+
+	if exifIfd, ok := tags[exifTagExifIFD].Int(); ok {
+		_ = parseIfd(data, order, uint32(exifIfd), tags)
+	}
+
+	return tags, nil
+}
+
+func parseIfd(data []byte, order binary.ByteOrder, offset uint32, tags map[uint16]IExifValue) error {
+	if int(offset)+2 > len(data) {
+		return errInvalidExif
+	}
+
+	sizes := map[uint16]uint32{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+	entries := int(order.Uint16(data[offset:]))
+	for index := 0; index < entries; index++ {
+		entry := int(offset) + 2 + index*12
+		if entry+12 > len(data) {
+			return errInvalidExif
+		}
+
+		tag := order.Uint16(data[entry:])
+		kind := order.Uint16(data[entry+2:])
+		count := order.Uint32(data[entry+4:])
+
+		size, ok := sizes[kind]
+		if !ok || count > 1<<16 {
+			continue
+		}
+
+		total := size * count
+		start := uint32(entry + 8)
+		if total > 4 {
+			start = order.Uint32(data[entry+8:])
+		}
+
+		if uint64(start)+uint64(total) > uint64(len(data)) {
+			continue
+		}
+
+		tags[tag] = IExifValue{
+			order:  order,
+			kind:   kind,
+			values: data[start : start+total],
+		}
+	}
+
+	return nil
+}
+
+// orientImage applies the transformation described by an EXIF orientation tag,
+// copying every pixel straight into the rotated image.
+func orientImage(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	destinationWidth, destinationHeight := width, height
+	if orientation >= 5 {
+		destinationWidth, destinationHeight = height, width
+	}
+
+	destination := image.NewRGBA(image.Rect(0, 0, destinationWidth, destinationHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = width-1-x, y
+			case 3:
+				dx, dy = width-1-x, height-1-y
+			case 4:
+				dx, dy = x, height-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = height-1-y, x
+			case 7:
+				dx, dy = height-1-y, width-1-x
+			case 8:
+				dx, dy = y, width-1-x
+			default:
+				dx, dy = x, y
+			}
+
+			destination.SetRGBA(dx, dy, rgbaAt(img, bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+
+	return destination
+}
+
+// rgbaAt reads a pixel of the image types returned by the JPEG decoder without
+// going through the color.Color interface.
+func rgbaAt(img image.Image, x int, y int) color.RGBA {
+	switch source := img.(type) {
+	case *image.YCbCr:
+		pixel := source.YCbCrAt(x, y)
+		r, g, b := color.YCbCrToRGB(pixel.Y, pixel.Cb, pixel.Cr)
+		return color.RGBA{R: r, G: g, B: b, A: 0xFF}
+	case *image.Gray:
+		pixel := source.GrayAt(x, y)
+		return color.RGBA{R: pixel.Y, G: pixel.Y, B: pixel.Y, A: 0xFF}
+	}
+
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+}
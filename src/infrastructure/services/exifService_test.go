@@ -0,0 +1,318 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// testTiff builds a TIFF header with IFD0 holding the orientation and, when
+// maker is set, a pointer to an EXIF sub-IFD holding the Make tag as ASCII.
+func testTiff(order binary.ByteOrder, orientation uint16, maker string) []byte {
+	var buffer bytes.Buffer
+	if order == binary.LittleEndian {
+		buffer.WriteString("II")
+	} else {
+		buffer.WriteString("MM")
+	}
+	_ = binary.Write(&buffer, order, uint16(42))
+	_ = binary.Write(&buffer, order, uint32(8))
+
+	entries := uint16(1)
+	if maker != "" {
+		entries = 2
+	}
+
+	// IFD0 starts at 8: count, entries and the next IFD offset.
+	exifIfd := uint32(8 + 2 + int(entries)*12 + 4)
+
+	_ = binary.Write(&buffer, order, entries)
+	_ = binary.Write(&buffer, order, []uint16{exifTagOrientation, 3})
+	_ = binary.Write(&buffer, order, uint32(1))
+	_ = binary.Write(&buffer, order, []uint16{orientation, 0})
+	if maker != "" {
+		_ = binary.Write(&buffer, order, []uint16{exifTagExifIFD, 4})
+		_ = binary.Write(&buffer, order, uint32(1))
+		_ = binary.Write(&buffer, order, exifIfd)
+	}
+	_ = binary.Write(&buffer, order, uint32(0))
+
+	if maker != "" {
+		value := append([]byte(maker), 0)
+		valueOffset := exifIfd + 2 + 12 + 4
+
+		_ = binary.Write(&buffer, order, uint16(1))
+		_ = binary.Write(&buffer, order, []uint16{0x010F, 2})
+		_ = binary.Write(&buffer, order, uint32(len(value)))
+		_ = binary.Write(&buffer, order, valueOffset)
+		_ = binary.Write(&buffer, order, uint32(0))
+		buffer.Write(value)
+	}
+
+	return buffer.Bytes()
+}
+
+// testJpeg encodes a width x height image whose left half is red and right half
+// blue, and inserts the given segments right after SOI.
+func testJpeg(t *testing.T, width int, height int, segments ...[]byte) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{R: 0xFF, A: 0xFF})
+			} else {
+				img.Set(x, y, color.RGBA{B: 0xFF, A: 0xFF})
+			}
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte{}, jpegSignature...)
+	for _, segment := range segments {
+		data = append(data, segment...)
+	}
+
+	return append(data, encoded.Bytes()[len(jpegSignature):]...)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return jpegSegment(0xE1, append(append([]byte{}, exifHeader...), tiff...))
+}
+
+func TestWalkJpeg(t *testing.T) {
+	data := testJpeg(t, 16, 8, exifSegment(testTiff(binary.BigEndian, 1, "")), jpegSegment(0xFE, []byte("comment")))
+
+	var markers []byte
+	offset, err := walkJpeg(data, func(marker byte, segment []byte) bool {
+		markers = append(markers, marker)
+		return true
+	})
+	if err != nil {
+		t.Fatalf("walkJpeg() error = %v", err)
+	}
+
+	if len(markers) < 3 || markers[0] != 0xE1 || markers[1] != 0xFE {
+		t.Fatalf("walkJpeg() visited %x, want APP1 and COM first", markers)
+	}
+
+	if data[offset] != 0xFF || data[offset+1] != 0xDA {
+		t.Fatalf("walkJpeg() offset %d is not the start of scan", offset)
+	}
+
+	stopped, err := walkJpeg(data, func(marker byte, segment []byte) bool {
+		return marker != 0xFE
+	})
+	if err != nil {
+		t.Fatalf("walkJpeg() error = %v", err)
+	}
+
+	if data[stopped+1] != 0xFE {
+		t.Fatalf("walkJpeg() stopped at marker %x, want FE", data[stopped+1])
+	}
+}
+
+func TestWalkJpegInvalid(t *testing.T) {
+	valid := testJpeg(t, 8, 8)
+
+	tests := map[string][]byte{
+		"not a jpeg":        []byte("GIF89a"),
+		"empty":             nil,
+		"no start of scan":  append(append([]byte{}, jpegSignature...), jpegSegment(0xE1, []byte("Exif"))...),
+		"truncated segment": append(append([]byte{}, jpegSignature...), 0xFF, 0xE1, 0x10, 0x00, 'E'),
+		"short length":      append(append([]byte{}, jpegSignature...), 0xFF, 0xE1, 0x00, 0x01, 0xFF, 0xDA),
+		"missing marker":    append(append([]byte{}, jpegSignature...), valid[3:]...),
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := walkJpeg(data, func(byte, []byte) bool { return true }); err == nil {
+				t.Fatal("walkJpeg() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestParseTiff(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			tags, err := parseTiff(testTiff(order, 6, "Camera"))
+			if err != nil {
+				t.Fatalf("parseTiff() error = %v", err)
+			}
+
+			if orientation, ok := tags[exifTagOrientation].Int(); !ok || orientation != 6 {
+				t.Fatalf("orientation = %d, %v, want 6", orientation, ok)
+			}
+
+			if maker := tags[0x010F].String(); maker != "Camera" {
+				t.Fatalf("make = %q, want Camera", maker)
+			}
+		})
+	}
+}
+
+func TestParseTiffInvalid(t *testing.T) {
+	valid := testTiff(binary.LittleEndian, 3, "")
+
+	tests := map[string][]byte{
+		"empty":            nil,
+		"short":            valid[:6],
+		"byte order":       append([]byte("XX"), valid[2:]...),
+		"ifd out of range": append(append([]byte{}, valid[:4]...), 0xFF, 0xFF, 0x00, 0x00),
+		"truncated ifd":    valid[:12],
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseTiff(data); !errors.Is(err, errInvalidExif) {
+				t.Fatalf("parseTiff() error = %v, want %v", err, errInvalidExif)
+			}
+		})
+	}
+}
+
+func TestParseTiffSkipsValuesOutOfRange(t *testing.T) {
+	data := testTiff(binary.BigEndian, 1, "Camera")
+
+	// Point the Make value past the end of the data.
+	binary.BigEndian.PutUint32(data[len(data)-len("Camera\x00")-4-4:], 0xFFFF)
+
+	tags, err := parseTiff(data)
+	if err != nil {
+		t.Fatalf("parseTiff() error = %v", err)
+	}
+
+	if _, found := tags[0x010F]; found {
+		t.Fatal("parseTiff() kept a value outside of the data")
+	}
+
+	if orientation, _ := tags[exifTagOrientation].Int(); orientation != 1 {
+		t.Fatalf("orientation = %d, want 1", orientation)
+	}
+}
+
+func TestStripImageMetadata(t *testing.T) {
+	data := testJpeg(t, 16, 8, exifSegment(testTiff(binary.LittleEndian, 1, "Camera")), jpegSegment(0xFE, []byte("comment")))
+
+	stripped, changed, err := StripImageMetadata(data, true)
+	if err != nil {
+		t.Fatalf("StripImageMetadata() error = %v", err)
+	}
+
+	if !changed {
+		t.Fatal("StripImageMetadata() did not change the image")
+	}
+
+	if bytes.Contains(stripped, exifHeader) || bytes.Contains(stripped, []byte("comment")) {
+		t.Fatal("StripImageMetadata() kept the EXIF or comment segment")
+	}
+
+	if _, err = jpeg.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Fatalf("stripped image does not decode: %v", err)
+	}
+
+	if _, changed, _ = StripImageMetadata(stripped, true); changed {
+		t.Fatal("StripImageMetadata() changed an image without metadata")
+	}
+}
+
+func TestStripImageMetadataRotates(t *testing.T) {
+	data := testJpeg(t, 16, 8, exifSegment(testTiff(binary.LittleEndian, 6, "")))
+
+	rotated, changed, err := StripImageMetadata(data, true)
+	if err != nil {
+		t.Fatalf("StripImageMetadata() error = %v", err)
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(rotated))
+	if err != nil {
+		t.Fatalf("rotated image does not decode: %v", err)
+	}
+
+	if !changed || img.Bounds().Dx() != 8 || img.Bounds().Dy() != 16 {
+		t.Fatalf("rotated image is %v, want 8x16", img.Bounds())
+	}
+
+	// Orientation 6 turns the red left half into the top half.
+	if r, _, b, _ := img.At(4, 2).RGBA(); r < b {
+		t.Fatal("top of the rotated image is not red")
+	}
+
+	if JpegOrientation(rotated) != 0 {
+		t.Fatal("rotated image kept its orientation tag")
+	}
+}
+
+func TestStripImageMetadataRejectsLargeImages(t *testing.T) {
+	data := testJpeg(t, 16, 8, exifSegment(testTiff(binary.LittleEndian, 6, "")))
+
+	// Declare a 65535x65535 image in the frame header without adding pixels.
+	_, err := walkJpeg(data, func(marker byte, segment []byte) bool {
+		if marker == 0xC0 {
+			binary.BigEndian.PutUint16(segment[1:], 0xFFFF)
+			binary.BigEndian.PutUint16(segment[3:], 0xFFFF)
+		}
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = StripImageMetadata(data, true); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Fatalf("StripImageMetadata() error = %v, want the image to be too large", err)
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 3; x++ {
+			img.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), A: 0xFF})
+		}
+	}
+
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		// Position of the source pixel (2, 0) in the result.
+		x, y int
+	}{
+		{orientation: 2, width: 3, height: 2, x: 0, y: 0},
+		{orientation: 3, width: 3, height: 2, x: 0, y: 1},
+		{orientation: 4, width: 3, height: 2, x: 2, y: 1},
+		{orientation: 5, width: 2, height: 3, x: 0, y: 2},
+		{orientation: 6, width: 2, height: 3, x: 1, y: 2},
+		{orientation: 7, width: 2, height: 3, x: 1, y: 0},
+		{orientation: 8, width: 2, height: 3, x: 0, y: 0},
+	}
+
+	for _, test := range tests {
+		oriented := orientImage(img, test.orientation).(*image.RGBA)
+
+		if oriented.Bounds().Dx() != test.width || oriented.Bounds().Dy() != test.height {
+			t.Fatalf("orientation %d: size %v, want %dx%d", test.orientation, oriented.Bounds(), test.width, test.height)
+		}
+
+		if pixel := oriented.RGBAAt(test.x, test.y); pixel.R != 2 || pixel.G != 0 {
+			t.Fatalf("orientation %d: pixel at %d,%d is %v, want the source pixel 2,0", test.orientation, test.x, test.y, pixel)
+		}
+	}
+}
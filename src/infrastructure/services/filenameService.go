@@ -0,0 +1,149 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
+	"path/filepath"
+	"storage-api/src/domain"
+	"strings"
+	"unicode"
+)
+
+const (
+	CollisionFail      = "fail"
+	CollisionOverwrite = "overwrite"
+	CollisionSuffix    = "suffix"
+	CollisionUuid      = "uuid"
+	maxFilenameLength  = 255
+	maxCollisionSuffix = 1000
+)
+
+var ErrInvalidFilename = errors.New("File name is not allowed")
+
+func IsCollisionStrategy(strategy string) bool {
+	switch strategy {
+	case CollisionFail, CollisionOverwrite, CollisionSuffix, CollisionUuid:
+		return true
+	}
+
+	return false
+}
+
+// CollisionStrategyFor returns the strategy configured for the most specific
+// folder that contains folder, or CollisionFail.
+func CollisionStrategyFor(folder string) string {
+	strategy, length := CollisionFail, -1
+	for candidate, candidateStrategy := range domain.CONFIG.CollisionStrategies {
+		if (candidate == "" || domain.FolderMatches(folder, []string{candidate})) && len(candidate) > length {
+			strategy, length = candidateStrategy, len(candidate)
+		}
+	}
+
+	return strategy
+}
+
+// SanitizeFilename decomposes the name (NFKD) to remove accents, drops path
+// separators, control characters and symbols, turns whitespace into hyphens and
+// strips leading dots so the name cannot reach hidden prefixes.
+func SanitizeFilename(filename string) (string, error) {
+	var sanitized strings.Builder
+	isSpace := false
+
+	for _, character := range norm.NFKD.String(filename) {
+		switch {
+		case unicode.Is(unicode.Mn, character):
+			continue
+		case unicode.IsSpace(character):
+			isSpace = true
+			continue
+		case unicode.IsLetter(character) || unicode.IsDigit(character) || strings.ContainsRune("._-()", character):
+		default:
+			// Separators, control characters and other symbols.
+			continue
+		}
+
+		if isSpace && sanitized.Len() > 0 {
+			sanitized.WriteByte('-')
+		}
+		isSpace = false
+
+		sanitized.WriteRune(character)
+	}
+
+	name := strings.TrimRight(strings.TrimLeft(norm.NFC.String(sanitized.String()), ".-"), ".")
+	if name == "" {
+		return "", fmt.Errorf("%w: %s", ErrInvalidFilename, filename)
+	}
+
+	if len(name) > maxFilenameLength {
+		extension := filepath.Ext(name)
+		if len(extension) > 16 {
+			extension = ""
+		}
+
+		name = strings.ToValidUTF8(name[:maxFilenameLength-len(extension)], "") + extension
+	}
+
+	return name, nil
+}
+
+// SanitizeFolder sanitises every segment of a folder path and drops the empty ones.
+func SanitizeFolder(folder string) (string, error) {
+	segments := make([]string, 0)
+	for _, segment := range strings.Split(strings.ReplaceAll(folder, "\\", "/"), "/") {
+		if strings.TrimSpace(segment) == "" {
+			continue
+		}
+
+		sanitized, err := SanitizeFilename(segment)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrInvalidFilename, folder)
+		}
+
+		segments = append(segments, sanitized)
+	}
+
+	if len(segments) == 0 {
+		return "", fmt.Errorf("%w: %s", ErrInvalidFilename, folder)
+	}
+
+	return strings.Join(segments, "/"), nil
+}
+
+// ResolveCollision returns the name to store filename under folder following
+// strategy, and whether an existing file will be replaced.
+func ResolveCollision(storage *ICloudflareService, folder string, filename string, strategy string) (string, bool, error) {
+	if strategy == CollisionUuid {
+		return uuid.NewString() + strings.ToLower(filepath.Ext(filename)), false, nil
+	}
+
+	exists, err := storage.FileExists(folder + "/" + filename)
+	if err != nil || !exists {
+		return filename, false, err
+	}
+
+	switch strategy {
+	case CollisionOverwrite:
+		return filename, true, nil
+	case CollisionSuffix:
+		extension := filepath.Ext(filename)
+		base := strings.TrimSuffix(filename, extension)
+
+		for index := 1; index <= maxCollisionSuffix; index++ {
+			candidate := fmt.Sprintf("%s (%d)%s", base, index, extension)
+
+			exists, err = storage.FileExists(folder + "/" + candidate)
+			if err != nil {
+				return "", false, err
+			}
+
+			if !exists {
+				return candidate, false, nil
+			}
+		}
+	}
+
+	return "", false, fmt.Errorf("%w: %s", ErrFileAlreadyExists, filename)
+}
@@ -0,0 +1,288 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// testObject is an object of the bucket served by testBucket. A status other
+// than zero is returned instead of the object, like R2 does for SSE-C objects
+// requested without their key.
+type testObject struct {
+	body     []byte
+	metadata map[string]string
+	etag     string
+	status   int
+}
+
+type testListResult struct {
+	XMLName     xml.Name          `xml:"ListBucketResult"`
+	Name        string            `xml:"Name"`
+	Prefix      string            `xml:"Prefix"`
+	KeyCount    int               `xml:"KeyCount"`
+	IsTruncated bool              `xml:"IsTruncated"`
+	Contents    []testListContent `xml:"Contents"`
+}
+
+type testListContent struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+// testBucket answers HEAD, GET and list requests from a local server as R2
+// would, with objects being the only ones in the bucket.
+func testBucket(t *testing.T, objects map[string]testObject) *ICloudflareService {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Query().Get("list-type") == "2" {
+			prefix := request.URL.Query().Get("prefix")
+
+			list := testListResult{Name: "bucket", Prefix: prefix}
+			for _, key := range slices.Sorted(maps.Keys(objects)) {
+				if strings.HasPrefix(key, prefix) {
+					list.Contents = append(list.Contents, testListContent{
+						Key:          key,
+						LastModified: "2024-01-01T00:00:00.000Z",
+						ETag:         `"` + objects[key].ETag() + `"`,
+						Size:         len(objects[key].body),
+					})
+				}
+			}
+			list.KeyCount = len(list.Contents)
+
+			writer.Header().Set("Content-Type", "application/xml")
+			_ = xml.NewEncoder(writer).Encode(list)
+			return
+		}
+
+		object, ok := objects[strings.TrimPrefix(request.URL.Path, "/bucket/")]
+		switch {
+		case !ok:
+			writer.WriteHeader(http.StatusNotFound)
+			if request.Method == http.MethodGet {
+				_, _ = writer.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			}
+			return
+		case object.status != 0:
+			writer.WriteHeader(object.status)
+			return
+		}
+
+		for name, value := range object.metadata {
+			writer.Header().Set("X-Amz-Meta-"+name, value)
+		}
+		writer.Header().Set("ETag", `"`+object.ETag()+`"`)
+		writer.Header().Set("Content-Length", strconv.Itoa(len(object.body)))
+		writer.WriteHeader(http.StatusOK)
+
+		if request.Method == http.MethodGet {
+			_, _ = writer.Write(object.body)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return &ICloudflareService{
+		Client: r2.New(r2.Options{
+			BaseEndpoint: aws.String(server.URL),
+			UsePathStyle: true,
+			Region:       "auto",
+			Credentials:  aws.AnonymousCredentials{},
+		}),
+		BucketName: "bucket",
+		Context:    context.Background(),
+	}
+}
+
+// ETag is the MD5 of the body, as R2 returns for single part uploads.
+func (o testObject) ETag() string {
+	if o.etag != "" {
+		return o.etag
+	}
+
+	digest := md5.Sum(o.body)
+	return hex.EncodeToString(digest[:])
+}
+
+// testStorage is a bucket with empty objects in existing.
+func testStorage(t *testing.T, existing ...string) *ICloudflareService {
+	t.Helper()
+
+	objects := make(map[string]testObject, len(existing))
+	for _, key := range existing {
+		objects[key] = testObject{}
+	}
+
+	return testBucket(t, objects)
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{filename: "report.pdf", want: "report.pdf"},
+		{filename: "Résumé final.pdf", want: "Resume-final.pdf"},
+		{filename: "a  b\tc.txt", want: "a-b-c.txt"},
+		{filename: "  leading space.txt", want: "leading-space.txt"},
+		{filename: "report (1).pdf", want: "report-(1).pdf"},
+		{filename: "../../etc/passwd", want: "etcpasswd"},
+		{filename: "..\\windows\\win.ini", want: "windowswin.ini"},
+		{filename: ".env", want: "env"},
+		{filename: "-rf", want: "rf"},
+		{filename: "trailing...", want: "trailing"},
+		{filename: "file\x00name.txt", want: "filename.txt"},
+		{filename: "name<>:\"|?*.txt", want: "name.txt"},
+		{filename: "ﬁle.txt", want: "file.txt"},
+		{filename: "日本語.txt", want: "日本語.txt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.filename, func(t *testing.T) {
+			sanitized, err := SanitizeFilename(test.filename)
+			if err != nil {
+				t.Fatalf("SanitizeFilename() error = %v", err)
+			}
+
+			if sanitized != test.want {
+				t.Fatalf("SanitizeFilename() = %q, want %q", sanitized, test.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameInvalid(t *testing.T) {
+	for _, filename := range []string{"", "...", "///", "   ", "<>", ".-."} {
+		t.Run(filename, func(t *testing.T) {
+			if _, err := SanitizeFilename(filename); !errors.Is(err, ErrInvalidFilename) {
+				t.Fatalf("SanitizeFilename() error = %v, want %v", err, ErrInvalidFilename)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameLength(t *testing.T) {
+	tests := map[string]string{
+		"ascii":             strings.Repeat("a", 300) + ".txt",
+		"multibyte":         strings.Repeat("日", 100) + ".txt",
+		"long extension":    "name." + strings.Repeat("x", 300),
+		"without extension": strings.Repeat("b", 300),
+	}
+
+	for name, filename := range tests {
+		t.Run(name, func(t *testing.T) {
+			sanitized, err := SanitizeFilename(filename)
+			if err != nil {
+				t.Fatalf("SanitizeFilename() error = %v", err)
+			}
+
+			if len(sanitized) > maxFilenameLength || !utf8.ValidString(sanitized) {
+				t.Fatalf("SanitizeFilename() returned %d bytes, want at most %d valid UTF-8", len(sanitized), maxFilenameLength)
+			}
+
+			if extension := filepath.Ext(filename); len(extension) <= 16 && !strings.HasSuffix(sanitized, extension) {
+				t.Fatalf("SanitizeFilename() = %q, lost the extension %s", sanitized, extension)
+			}
+		})
+	}
+}
+
+func TestSanitizeFolder(t *testing.T) {
+	tests := map[string]string{
+		"docs":            "docs",
+		"/docs/2024/":     "docs/2024",
+		"docs//old files": "docs/old-files",
+		"docs\\reports":   "docs/reports",
+		"Ñandú/.hidden":   "Nandu/hidden",
+	}
+
+	for folder, want := range tests {
+		t.Run(folder, func(t *testing.T) {
+			sanitized, err := SanitizeFolder(folder)
+			if err != nil {
+				t.Fatalf("SanitizeFolder() error = %v", err)
+			}
+
+			if sanitized != want {
+				t.Fatalf("SanitizeFolder() = %q, want %q", sanitized, want)
+			}
+		})
+	}
+
+	for _, folder := range []string{"", "/", "docs/../x", "docs/<>"} {
+		if _, err := SanitizeFolder(folder); !errors.Is(err, ErrInvalidFilename) {
+			t.Fatalf("SanitizeFolder(%q) error = %v, want %v", folder, err, ErrInvalidFilename)
+		}
+	}
+}
+
+func TestResolveCollision(t *testing.T) {
+	storage := testStorage(t, "docs/report.pdf", "docs/report (1).pdf", "docs/notes")
+
+	tests := []struct {
+		name     string
+		filename string
+		strategy string
+		want     string
+		replace  bool
+		err      error
+	}{
+		{name: "new file", filename: "new.pdf", strategy: CollisionFail, want: "new.pdf"},
+		{name: "fail", filename: "report.pdf", strategy: CollisionFail, err: ErrFileAlreadyExists},
+		{name: "overwrite", filename: "report.pdf", strategy: CollisionOverwrite, want: "report.pdf", replace: true},
+		{name: "overwrite new file", filename: "new.pdf", strategy: CollisionOverwrite, want: "new.pdf"},
+		{name: "suffix", filename: "report.pdf", strategy: CollisionSuffix, want: "report (2).pdf"},
+		{name: "suffix without extension", filename: "notes", strategy: CollisionSuffix, want: "notes (1)"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filename, replace, err := ResolveCollision(storage, "docs", test.filename, test.strategy)
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("ResolveCollision() error = %v, want %v", err, test.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ResolveCollision() error = %v", err)
+			}
+
+			if filename != test.want || replace != test.replace {
+				t.Fatalf("ResolveCollision() = %q, %v, want %q, %v", filename, replace, test.want, test.replace)
+			}
+		})
+	}
+}
+
+func TestResolveCollisionUuid(t *testing.T) {
+	storage := testStorage(t, "docs/report.pdf")
+
+	first, replace, err := ResolveCollision(storage, "docs", "report.PDF", CollisionUuid)
+	if err != nil || replace {
+		t.Fatalf("ResolveCollision() = %q, %v, %v", first, replace, err)
+	}
+
+	second, _, _ := ResolveCollision(storage, "docs", "report.PDF", CollisionUuid)
+
+	if !strings.HasSuffix(first, ".pdf") || len(first) != 36+len(".pdf") || first == second {
+		t.Fatalf("ResolveCollision() = %q and %q, want different UUIDs with the lower case extension", first, second)
+	}
+}
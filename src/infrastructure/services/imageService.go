@@ -0,0 +1,258 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/image/draw"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	VariantsPrefix            = ".variants/"
+	VariantSourceMetadata     = "source"
+	ImageFitContain           = "contain"
+	ImageFitCover             = "cover"
+	ImageFitFill              = "fill"
+	DefaultImageQuality       = 85
+	maxImagePixels            = 50_000_000
+	maxImageDimension         = 8192
+	defaultImageVariantFormat = "jpeg"
+)
+
+var ErrUnsupportedImage = errors.New("File is not a supported image")
+
+var imageContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+}
+
+type IImageOptions struct {
+	Width   int
+	Height  int
+	Fit     string
+	Quality int
+	Format  string
+}
+
+func (o IImageOptions) Validate() error {
+	if o.Width < 0 || o.Width > maxImageDimension || o.Height < 0 || o.Height > maxImageDimension {
+		return fmt.Errorf("Width and height must be between 0 and %d", maxImageDimension)
+	}
+
+	if o.Fit != ImageFitContain && o.Fit != ImageFitCover && o.Fit != ImageFitFill {
+		return fmt.Errorf("Fit must be one of: contain, cover, fill")
+	}
+
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("Quality must be between 1 and 100")
+	}
+
+	if o.Format != "" {
+		if _, ok := imageContentTypes[o.Format]; !ok {
+			return fmt.Errorf("Format must be one of: jpeg, png, gif")
+		}
+	}
+
+	return nil
+}
+
+func (o IImageOptions) variant(format string) string {
+	return fmt.Sprintf("%dx%d-%s-q%d.%s", o.Width, o.Height, o.Fit, o.Quality, format)
+}
+
+func imageFormatByExtension(filename string) string {
+	switch strings.ToLower(filename[strings.LastIndex(filename, ".")+1:]) {
+	case "png":
+		return "png"
+	case "gif":
+		return "gif"
+	default:
+		return defaultImageVariantFormat
+	}
+}
+
+func ImageContentType(format string) string {
+	return imageContentTypes[format]
+}
+
+func DecodeImage(data []byte) (image.Image, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+
+	if config.Width*config.Height > maxImagePixels {
+		return nil, "", fmt.Errorf("Image is too large: %dx%d", config.Width, config.Height)
+	}
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrUnsupportedImage
+	}
+
+	return img, format, nil
+}
+
+// ResizeImage scales img into a width x height box. A zero dimension is derived
+// from the other one keeping the aspect ratio.
+func ResizeImage(img image.Image, width int, height int, fit string) image.Image {
+	bounds := img.Bounds()
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+
+	if width == 0 && height == 0 {
+		return img
+	}
+
+	if width == 0 {
+		width = max(1, sourceWidth*height/sourceHeight)
+	} else if height == 0 {
+		height = max(1, sourceHeight*width/sourceWidth)
+	} else if fit == ImageFitContain {
+		if sourceWidth*height > sourceHeight*width {
+			height = max(1, sourceHeight*width/sourceWidth)
+		} else {
+			width = max(1, sourceWidth*height/sourceHeight)
+		}
+	} else if fit == ImageFitCover {
+		cropWidth, cropHeight := sourceWidth, sourceHeight
+		if sourceWidth*height > sourceHeight*width {
+			cropWidth = sourceHeight * width / height
+		} else {
+			cropHeight = sourceWidth * height / width
+		}
+
+		x := bounds.Min.X + (sourceWidth-cropWidth)/2
+		y := bounds.Min.Y + (sourceHeight-cropHeight)/2
+		bounds = image.Rect(x, y, x+cropWidth, y+cropHeight)
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(resized, resized.Bounds(), img, bounds, draw.Src, nil)
+
+	return resized
+}
+
+func EncodeImage(writer io.Writer, img image.Image, format string, quality int) error {
+	switch format {
+	case "png":
+		return png.Encode(writer, img)
+	case "gif":
+		return gif.Encode(writer, img, nil)
+	default:
+		opaque := image.NewRGBA(img.Bounds())
+		draw.Draw(opaque, opaque.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(opaque, opaque.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		return jpeg.Encode(writer, opaque, &jpeg.Options{Quality: quality})
+	}
+}
+
+type IImageService struct {
+	storage *ICloudflareService
+	dedupe  *IDedupeService
+}
+
+func ImageService(storage *ICloudflareService) *IImageService {
+	return &IImageService{
+		storage: storage,
+		dedupe:  DedupeService(storage),
+	}
+}
+
+// GetVariant returns the transformed image for filename, reusing the cached
+// variant while the source content has not changed.
+func (s *IImageService) GetVariant(filename string, options IImageOptions) ([]byte, string, error) {
+	head, err := s.storage.HeadFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+
+	source := head.Metadata[ChecksumMetadata]
+	if source == "" && head.ETag != nil {
+		source = strings.Trim(*head.ETag, `"`)
+	}
+
+	format := options.Format
+	if format == "" {
+		format = imageFormatByExtension(filename)
+	}
+
+	variantFolder := VariantsPrefix + filename
+	variantName := options.variant(format)
+
+	// Variants of encrypted files are not cached, as they would be stored in plain.
+	isEncrypted := IsEncrypted(head.Metadata) || s.storage.HasCustomerKey()
+
+	if !isEncrypted {
+		if cached, errCached := s.storage.GetFile(variantFolder + "/" + variantName); errCached == nil {
+			defer cached.Body.Close()
+
+			if cached.Metadata[VariantSourceMetadata] == source {
+				data, errRead := io.ReadAll(cached.Body)
+				if errRead == nil {
+					return data, ImageContentType(format), nil
+				}
+			}
+		}
+	}
+
+	file, err := s.dedupe.GetFile(filename)
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Body.Close()
+
+	data, err := io.ReadAll(file.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, _, err := DecodeImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buffer bytes.Buffer
+	if err = EncodeImage(&buffer, ResizeImage(img, options.Width, options.Height, options.Fit), format, options.Quality); err != nil {
+		return nil, "", err
+	}
+
+	if isEncrypted {
+		return buffer.Bytes(), ImageContentType(format), nil
+	}
+
+	_, err = s.storage.UploadFile(bytes.NewReader(buffer.Bytes()), variantFolder, variantName, ImageContentType(format), map[string]string{
+		VariantSourceMetadata: source,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return buffer.Bytes(), ImageContentType(format), nil
+}
+
+func (s *IImageService) DeleteVariants(filename string) error {
+	variants, err := s.storage.GetAllFiles(VariantsPrefix + filename + "/")
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range variants {
+		if _, err = s.storage.DeleteFile(*variant.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
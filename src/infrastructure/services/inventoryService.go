@@ -0,0 +1,378 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3/log"
+	"io"
+	"path/filepath"
+	"sort"
+	"storage-api/src/domain"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	InventoryPrefix      = ".reports/inventory/"
+	InventoryFormatCsv   = "csv"
+	InventoryFormatJsonl = "jsonl"
+	inventoryTimeLayout  = "20060102T150405Z"
+)
+
+var (
+	ErrInvalidInventory = errors.New("Inventory is not valid")
+	ErrInventoryRunning = errors.New("Inventory export is already running")
+	inventoryColumns    = []string{"key", "size", "etag", "lastModified", "contentType"}
+)
+
+// inventoryRunning is set while an export lists the bucket, so that requests
+// and the scheduler do not start another one.
+var inventoryRunning atomic.Bool
+
+type IInventoryEntry struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"lastModified"`
+	ContentType  string    `json:"contentType"`
+}
+
+type IInventorySnapshot struct {
+	Name      string    `json:"name"`
+	Format    string    `json:"format"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type IInventoryChange struct {
+	Key    string          `json:"key"`
+	Before IInventoryEntry `json:"before"`
+	After  IInventoryEntry `json:"after"`
+}
+
+type IInventoryDiff struct {
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	Added   []IInventoryEntry  `json:"added"`
+	Removed []IInventoryEntry  `json:"removed"`
+	Changed []IInventoryChange `json:"changed"`
+}
+
+type IInventoryService struct {
+	storage *ICloudflareService
+}
+
+func InventoryService(storage *ICloudflareService) *IInventoryService {
+	return &IInventoryService{
+		storage: storage,
+	}
+}
+
+func IsInventoryFormat(format string) bool {
+	return format == InventoryFormatCsv || format == InventoryFormatJsonl
+}
+
+// InventoryName returns the name of the snapshot taken at createdAt, which
+// sorts in chronological order.
+func InventoryName(format string, createdAt time.Time) string {
+	return createdAt.UTC().Format(inventoryTimeLayout) + "." + format
+}
+
+// ParseInventoryName returns the format and the creation time of a snapshot
+// name given by InventoryName.
+func ParseInventoryName(name string) (string, time.Time, error) {
+	format := strings.TrimPrefix(filepath.Ext(name), ".")
+
+	createdAt, err := time.Parse(inventoryTimeLayout, strings.TrimSuffix(name, "."+format))
+	if err != nil || !IsInventoryFormat(format) {
+		return "", time.Time{}, fmt.Errorf("%w: %s is not an inventory name", ErrInvalidInventory, name)
+	}
+
+	return format, createdAt, nil
+}
+
+// Export lists every object in the bucket, except the reports, and writes the
+// inventory under the inventory prefix with the given name. The content type
+// is not part of the listing, so every object is read.
+func (s *IInventoryService) Export(name string) (int, error) {
+	objects, err := s.storage.GetAllFiles("")
+	if err != nil {
+		return 0, err
+	}
+
+	entries := make([]IInventoryEntry, 0, len(objects))
+	for _, object := range objects {
+		if strings.HasPrefix(*object.Key, InventoryPrefix) {
+			continue
+		}
+
+		entry := IInventoryEntry{
+			Key: *object.Key,
+		}
+		if object.Size != nil {
+			entry.Size = *object.Size
+		}
+		if object.ETag != nil {
+			entry.ETag = strings.Trim(*object.ETag, `"`)
+		}
+		if object.LastModified != nil {
+			entry.LastModified = object.LastModified.UTC()
+		}
+
+		if head, errHead := s.storage.HeadFile(entry.Key); errHead == nil && head.ContentType != nil {
+			entry.ContentType = *head.ContentType
+		}
+
+		entries = append(entries, entry)
+	}
+
+	var buffer bytes.Buffer
+	contentType := "application/x-ndjson"
+
+	if strings.HasSuffix(name, "."+InventoryFormatCsv) {
+		contentType = "text/csv"
+
+		writer := csv.NewWriter(&buffer)
+		_ = writer.Write(inventoryColumns)
+		for _, entry := range entries {
+			_ = writer.Write([]string{
+				entry.Key,
+				strconv.FormatInt(entry.Size, 10),
+				entry.ETag,
+				entry.LastModified.Format(time.RFC3339),
+				entry.ContentType,
+			})
+		}
+
+		writer.Flush()
+		if err = writer.Error(); err != nil {
+			return 0, err
+		}
+	} else {
+		encoder := json.NewEncoder(&buffer)
+		for _, entry := range entries {
+			if err = encoder.Encode(entry); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	_, err = s.storage.UploadFile(&buffer, strings.TrimSuffix(InventoryPrefix, "/"), name, contentType, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(entries), nil
+}
+
+func (s *IInventoryService) GetSnapshots() ([]IInventorySnapshot, error) {
+	objects, err := s.storage.GetAllFiles(InventoryPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]IInventorySnapshot, 0, len(objects))
+	for _, object := range objects {
+		name := strings.TrimPrefix(*object.Key, InventoryPrefix)
+
+		format, createdAt, errName := ParseInventoryName(name)
+		if errName != nil {
+			continue
+		}
+
+		snapshot := IInventorySnapshot{
+			Name:      name,
+			Format:    format,
+			CreatedAt: createdAt,
+		}
+		if object.Size != nil {
+			snapshot.Size = *object.Size
+		}
+
+		snapshots = append(snapshots, snapshot)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt)
+	})
+
+	return snapshots, nil
+}
+
+// Diff compares two snapshots by key: an object is changed when its size, ETag
+// or modification time differ. Dedupe references are empty objects, so only
+// their modification time changes when they are overwritten.
+func (s *IInventoryService) Diff(from string, to string) (*IInventoryDiff, error) {
+	before, err := s.readSnapshot(from)
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := s.readSnapshot(to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &IInventoryDiff{
+		From:    from,
+		To:      to,
+		Added:   make([]IInventoryEntry, 0),
+		Removed: make([]IInventoryEntry, 0),
+		Changed: make([]IInventoryChange, 0),
+	}
+
+	for key, entry := range after {
+		previous, found := before[key]
+		switch {
+		case !found:
+			diff.Added = append(diff.Added, entry)
+		case previous.Size != entry.Size || previous.ETag != entry.ETag || !previous.LastModified.Equal(entry.LastModified):
+			diff.Changed = append(diff.Changed, IInventoryChange{Key: key, Before: previous, After: entry})
+		}
+	}
+
+	for key, entry := range before {
+		if _, found := after[key]; !found {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].Key < diff.Added[j].Key })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].Key < diff.Removed[j].Key })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].Key < diff.Changed[j].Key })
+
+	return diff, nil
+}
+
+func (s *IInventoryService) readSnapshot(name string) (map[string]IInventoryEntry, error) {
+	format, _, err := ParseInventoryName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.storage.GetFile(InventoryPrefix + name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Body.Close()
+
+	entries := make(map[string]IInventoryEntry)
+
+	switch format {
+	case InventoryFormatCsv:
+		reader := csv.NewReader(file.Body)
+
+		header, errHeader := reader.Read()
+		if errHeader != nil || strings.Join(header, ",") != strings.Join(inventoryColumns, ",") {
+			return nil, fmt.Errorf("%w: %s has an unexpected header", ErrInvalidInventory, name)
+		}
+
+		for {
+			record, errRecord := reader.Read()
+			if errRecord != nil {
+				if errors.Is(errRecord, io.EOF) {
+					break
+				}
+
+				return nil, fmt.Errorf("%w: %s", ErrInvalidInventory, errRecord.Error())
+			}
+
+			size, _ := strconv.ParseInt(record[1], 10, 64)
+			lastModified, _ := time.Parse(time.RFC3339, record[3])
+
+			entries[record[0]] = IInventoryEntry{
+				Key:          record[0],
+				Size:         size,
+				ETag:         record[2],
+				LastModified: lastModified,
+				ContentType:  record[4],
+			}
+		}
+	case InventoryFormatJsonl:
+		scanner := bufio.NewScanner(file.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+		for scanner.Scan() {
+			if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+				continue
+			}
+
+			var entry IInventoryEntry
+			if errEntry := json.Unmarshal(scanner.Bytes(), &entry); errEntry != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidInventory, errEntry.Error())
+			}
+
+			entries[entry.Key] = entry
+		}
+
+		if errScan := scanner.Err(); errScan != nil {
+			return nil, errScan
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s is not a CSV or JSONL inventory", ErrInvalidInventory, name)
+	}
+
+	return entries, nil
+}
+
+// InventoryCollector writes the inventory with the given name in the background.
+// It fails while another export is running.
+func InventoryCollector(name string) error {
+	if !inventoryRunning.CompareAndSwap(false, true) {
+		return ErrInventoryRunning
+	}
+
+	go func() {
+		defer inventoryRunning.Store(false)
+
+		storage := CloudflareService()
+		if storage == nil {
+			return
+		}
+
+		exportInventory(InventoryService(storage), name)
+	}()
+
+	return nil
+}
+
+// InventoryScheduler writes an inventory every INVENTORY_INTERVAL hours.
+func InventoryScheduler() {
+	go func() {
+		storage := CloudflareService()
+		if storage == nil {
+			return
+		}
+
+		inventory := InventoryService(storage)
+
+		for range time.Tick(time.Duration(domain.CONFIG.InventoryInterval) * time.Hour) {
+			if !inventoryRunning.CompareAndSwap(false, true) {
+				domain.Logger.Info("Scheduled inventory skipped: " + ErrInventoryRunning.Error())
+				continue
+			}
+
+			exportInventory(inventory, InventoryName(domain.CONFIG.InventoryFormat, time.Now()))
+			inventoryRunning.Store(false)
+		}
+	}()
+}
+
+func exportInventory(inventory *IInventoryService, name string) {
+	count, err := inventory.Export(name)
+	if err != nil {
+		domain.Logger.Error("Error exporting inventory " + name + ": " + err.Error())
+		return
+	}
+
+	text := fmt.Sprintf("Inventory %s exported: %d object(s)", name, count)
+
+	log.Debug(text)
+	domain.Logger.Info(text)
+}
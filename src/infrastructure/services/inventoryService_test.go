@@ -0,0 +1,101 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseInventoryName(t *testing.T) {
+	createdAt := time.Date(2026, time.October, 19, 15, 4, 5, 0, time.UTC)
+
+	for _, format := range []string{InventoryFormatCsv, InventoryFormatJsonl} {
+		parsedFormat, parsedAt, err := ParseInventoryName(InventoryName(format, createdAt))
+		if err != nil || parsedFormat != format || !parsedAt.Equal(createdAt) {
+			t.Fatalf("ParseInventoryName() = %s, %v, %v, want %s, %v", parsedFormat, parsedAt, err, format, createdAt)
+		}
+	}
+
+	for _, name := range []string{"", "latest.csv", "20261019T150405Z.txt", "20261019T150405Z", "../../docs/20261019T150405Z.csv", "20261019T150405Z.csv/../../docs/a.csv"} {
+		if _, _, err := ParseInventoryName(name); !errors.Is(err, ErrInvalidInventory) {
+			t.Fatalf("ParseInventoryName(%q) error = %v, want %v", name, err, ErrInvalidInventory)
+		}
+	}
+}
+
+func TestInventoryDiff(t *testing.T) {
+	before := "key,size,etag,lastModified,contentType\n" +
+		"docs/kept.pdf,10,aaa,2026-10-18T10:00:00Z,application/pdf\n" +
+		"docs/removed.pdf,20,bbb,2026-10-18T10:00:00Z,application/pdf\n" +
+		"docs/resized.pdf,30,ccc,2026-10-18T10:00:00Z,application/pdf\n" +
+		"\"docs/a,b.pdf\",40,ddd,2026-10-18T10:00:00Z,application/pdf\n" +
+		"docs/reference.pdf,0,eee,2026-10-18T10:00:00Z,application/pdf\n"
+
+	after := `{"key":"docs/kept.pdf","size":10,"etag":"aaa","lastModified":"2026-10-18T10:00:00Z","contentType":"application/pdf"}
+{"key":"docs/resized.pdf","size":31,"etag":"fff","lastModified":"2026-10-19T10:00:00Z","contentType":"application/pdf"}
+{"key":"docs/a,b.pdf","size":40,"etag":"ddd","lastModified":"2026-10-18T10:00:00Z","contentType":"application/pdf"}
+
+{"key":"docs/reference.pdf","size":0,"etag":"eee","lastModified":"2026-10-19T10:00:00Z","contentType":"application/pdf"}
+{"key":"docs/added.pdf","size":50,"etag":"ggg","lastModified":"2026-10-19T10:00:00Z","contentType":"application/pdf"}
+`
+
+	storage := testBucket(t, map[string]testObject{
+		InventoryPrefix + "20261018T100000Z.csv":   {body: []byte(before)},
+		InventoryPrefix + "20261019T100000Z.jsonl": {body: []byte(after)},
+		InventoryPrefix + "20261020T100000Z.csv":   {body: []byte("key,size\ndocs/kept.pdf,10\n")},
+		InventoryPrefix + "20261021T100000Z.csv":   {body: []byte("key,size,etag,lastModified,contentType\ndocs/kept.pdf,10\n")},
+		InventoryPrefix + "20261022T100000Z.jsonl": {body: []byte("{\"key\":\n")},
+		"docs/20261018T100000Z.csv":                {body: []byte(before)},
+	})
+
+	diff, err := InventoryService(storage).Diff("20261018T100000Z.csv", "20261019T100000Z.jsonl")
+	if err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+
+	if len(diff.Added) != 1 || diff.Added[0].Key != "docs/added.pdf" || diff.Added[0].Size != 50 {
+		t.Fatalf("added = %+v, want docs/added.pdf", diff.Added)
+	}
+
+	if len(diff.Removed) != 1 || diff.Removed[0].Key != "docs/removed.pdf" || diff.Removed[0].ETag != "bbb" {
+		t.Fatalf("removed = %+v, want docs/removed.pdf", diff.Removed)
+	}
+
+	if len(diff.Changed) != 2 || diff.Changed[0].Key != "docs/reference.pdf" || diff.Changed[1].Key != "docs/resized.pdf" {
+		t.Fatalf("changed = %+v, want docs/reference.pdf and docs/resized.pdf", diff.Changed)
+	}
+
+	resized := diff.Changed[1]
+	if resized.Before.Size != 30 || resized.After.Size != 31 || resized.After.ETag != "fff" {
+		t.Fatalf("docs/resized.pdf change = %+v, want 30 to 31 bytes", resized)
+	}
+
+	tests := map[string][2]string{
+		"unexpected header": {"20261018T100000Z.csv", "20261020T100000Z.csv"},
+		"short record":      {"20261018T100000Z.csv", "20261021T100000Z.csv"},
+		"invalid JSON":      {"20261022T100000Z.jsonl", "20261019T100000Z.jsonl"},
+		"outside prefix":    {"../../docs/20261018T100000Z.csv", "20261019T100000Z.jsonl"},
+		"not a snapshot":    {"20261018T100000Z.csv", "latest.csv"},
+	}
+
+	for name, snapshots := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := InventoryService(storage).Diff(snapshots[0], snapshots[1]); !errors.Is(err, ErrInvalidInventory) {
+				t.Fatalf("Diff() error = %v, want %v", err, ErrInvalidInventory)
+			}
+		})
+	}
+
+	if _, err = InventoryService(storage).Diff("20261018T100000Z.csv", "20261023T100000Z.csv"); !errors.Is(err, ErrFileNotExist) {
+		t.Fatalf("Diff() with a missing snapshot error = %v, want %v", err, ErrFileNotExist)
+	}
+}
+
+func TestInventoryCollectorRunning(t *testing.T) {
+	inventoryRunning.Store(true)
+	t.Cleanup(func() { inventoryRunning.Store(false) })
+
+	if err := InventoryCollector(InventoryName(InventoryFormatCsv, time.Now())); !errors.Is(err, ErrInventoryRunning) {
+		t.Fatalf("InventoryCollector() error = %v, want %v", err, ErrInventoryRunning)
+	}
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"path/filepath"
+	"regexp"
+	"storage-api/src/domain"
+	"strings"
+	"time"
+)
+
+var keyPlaceholderRegexp = regexp.MustCompile(`\{[a-z]+\}`)
+
+// KeyTemplateFor returns the key template configured for the most specific
+// folder that contains folder, or an empty string.
+func KeyTemplateFor(folder string) string {
+	template, length := "", -1
+	for candidate, candidateTemplate := range domain.CONFIG.KeyTemplates {
+		if (candidate == "" || domain.FolderMatches(folder, []string{candidate})) && len(candidate) > length {
+			template, length = candidateTemplate, len(candidate)
+		}
+	}
+
+	return template
+}
+
+// ApplyKeyTemplate expands template for a file uploaded to folder and returns
+// the folder and the file name of the resulting key. The template may contain
+// "/" to create subfolders, and every segment is sanitised like a file name.
+// The name must keep an extension to be read and deleted as a file.
+func ApplyKeyTemplate(template string, folder string, filename string, hash string, now time.Time) (string, string, error) {
+	extension := filepath.Ext(filename)
+	now = now.UTC()
+
+	values := map[string]string{
+		"{yyyy}": now.Format("2006"),
+		"{mm}":   now.Format("01"),
+		"{dd}":   now.Format("02"),
+		"{hh}":   now.Format("15"),
+		"{uuid}": uuid.NewString(),
+		"{hash}": hash,
+		"{name}": strings.TrimSuffix(filename, extension),
+		"{ext}":  strings.ToLower(strings.TrimPrefix(extension, ".")),
+	}
+
+	expanded := keyPlaceholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		return values[placeholder]
+	})
+
+	segments := strings.Split(strings.Trim(expanded, "/"), "/")
+	name, err := SanitizeFilename(segments[len(segments)-1])
+	if err != nil {
+		return "", "", fmt.Errorf("%w: template '%s' gives an empty name", ErrInvalidFilename, template)
+	}
+
+	if !strings.Contains(name, ".") {
+		return "", "", fmt.Errorf("%w: template '%s' gives a name without extension", ErrInvalidFilename, template)
+	}
+
+	if len(segments) > 1 {
+		subfolder, errFolder := SanitizeFolder(strings.Join(segments[:len(segments)-1], "/"))
+		if errFolder != nil {
+			return "", "", errFolder
+		}
+
+		folder = folder + "/" + subfolder
+	}
+
+	return folder, name, nil
+}
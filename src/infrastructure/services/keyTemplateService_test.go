@@ -0,0 +1,66 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"storage-api/src/domain"
+	"testing"
+	"time"
+)
+
+func TestApplyKeyTemplate(t *testing.T) {
+	now := time.Date(2024, time.March, 5, 7, 30, 0, 0, time.FixedZone("CET", 3600))
+	hash := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+
+	tests := []struct {
+		template string
+		filename string
+		folder   string
+		name     string
+	}{
+		{template: "{yyyy}/{mm}/{dd}/{name}.{ext}", filename: "Report.PDF", folder: "invoices/2024/03/05", name: "Report.pdf"},
+		{template: "{hh}-{name}.{ext}", filename: "report.pdf", folder: "invoices", name: "06-report.pdf"},
+		{template: "{hash}.{ext}", filename: "avatar.png", folder: "invoices", name: hash + ".png"},
+		{template: "/{yyyy}//{name}.{ext}/", filename: "notes.md", folder: "invoices/2024", name: "notes.md"},
+		{template: "{name} copy.{ext}", filename: "my report.txt", folder: "invoices", name: "my-report-copy.txt"},
+		{template: "{unknown}{name}.{ext}", filename: "report.pdf", folder: "invoices", name: "report.pdf"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.template, func(t *testing.T) {
+			folder, name, err := ApplyKeyTemplate(test.template, "invoices", test.filename, hash, now)
+			if err != nil {
+				t.Fatalf("ApplyKeyTemplate() error = %v", err)
+			}
+
+			if folder != test.folder || name != test.name {
+				t.Fatalf("ApplyKeyTemplate() = %q, %q, want %q, %q", folder, name, test.folder, test.name)
+			}
+
+			if _, errKey := domain.ObjectKey(folder + "/" + name); errKey != nil {
+				t.Fatalf("ApplyKeyTemplate() gives an invalid key: %v", errKey)
+			}
+		})
+	}
+}
+
+func TestApplyKeyTemplateUuid(t *testing.T) {
+	folder, name, err := ApplyKeyTemplate("{uuid}.{ext}", "avatars", "photo.JPG", "", time.Now())
+	if err != nil {
+		t.Fatalf("ApplyKeyTemplate() error = %v", err)
+	}
+
+	if folder != "avatars" || !regexp.MustCompile(`^[0-9a-f-]{36}\.jpg$`).MatchString(name) {
+		t.Fatalf("ApplyKeyTemplate() = %q, %q, want a UUID name in avatars", folder, name)
+	}
+}
+
+func TestApplyKeyTemplateInvalid(t *testing.T) {
+	for _, template := range []string{"{hash}", "../{name}", "{yyyy}/../{hash}", "docs/<>/{name}", "{yyyy}/{uuid}", "{name}.{ext}"} {
+		t.Run(template, func(t *testing.T) {
+			if _, _, err := ApplyKeyTemplate(template, "invoices", "report", "", time.Now()); !errors.Is(err, ErrInvalidFilename) {
+				t.Fatalf("ApplyKeyTemplate() error = %v, want %v", err, ErrInvalidFilename)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+const (
+	MediaMetadataPrefix = "media-"
+	maxMetadataValue    = 256
+)
+
+var exifMediaTags = map[uint16]string{
+	0x010F: "camera-make",
+	0x0110: "camera-model",
+	0x9003: "taken-at",
+	0x829A: "exposure-time",
+	0x829D: "f-number",
+	0x8827: "iso",
+	0x920A: "focal-length",
+}
+
+var id3MediaFrames = map[string]string{
+	"TIT2": "title",
+	"TPE1": "artist",
+	"TALB": "album",
+	"TYER": "year",
+	"TDRC": "year",
+}
+
+// ExtractMediaMetadata parses image headers, EXIF, ID3 tags and MP4 atoms and
+// returns the results as object metadata prefixed with MediaMetadataPrefix.
+func ExtractMediaMetadata(data []byte) map[string]string {
+	media := make(map[string]string)
+
+	if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		media["format"] = format
+		media["width"] = strconv.Itoa(config.Width)
+		media["height"] = strconv.Itoa(config.Height)
+
+		if tags, errExif := JpegExif(data); errExif == nil {
+			for tag, name := range exifMediaTags {
+				if value := exifMediaValue(tags[tag]); value != "" {
+					media[name] = value
+				}
+			}
+		}
+	} else if bytes.HasPrefix(data, []byte("ID3")) || isMpegFrame(data) {
+		extractMp3(data, media)
+	} else if len(data) >= 8 && string(data[4:8]) == "ftyp" {
+		extractMp4(data, media)
+	}
+
+	metadata := make(map[string]string, len(media))
+	for name, value := range media {
+		metadata[MediaMetadataPrefix+name] = EncodeMetadataValue(value)
+	}
+
+	return metadata
+}
+
+// DetectContentType sniffs the MIME type of data. Unknown binary content and
+// plain text use the type of the file extension, which is more specific.
+func DetectContentType(filename string, data []byte) string {
+	contentType := http.DetectContentType(data)
+	if contentType == "application/octet-stream" || strings.HasPrefix(contentType, "text/plain") {
+		if byExtension := mime.TypeByExtension(filepath.Ext(filename)); byExtension != "" {
+			return byExtension
+		}
+	}
+
+	return contentType
+}
+
+// MediaMetadata returns the media entries of an object metadata without prefix.
+func MediaMetadata(metadata map[string]string) map[string]string {
+	media := make(map[string]string)
+	for name, value := range metadata {
+		if strings.HasPrefix(name, MediaMetadataPrefix) {
+			media[strings.TrimPrefix(name, MediaMetadataPrefix)] = DecodeMetadataValue(value)
+		}
+	}
+
+	return media
+}
+
+// EncodeMetadataValue keeps metadata values header-safe: non-ASCII text is
+// stored as an RFC 2047 encoded word.
+func EncodeMetadataValue(value string) string {
+	value = strings.TrimSpace(strings.ToValidUTF8(value, ""))
+	if len(value) > maxMetadataValue {
+		value = value[:maxMetadataValue]
+		for !utf8.ValidString(value) {
+			value = value[:len(value)-1]
+		}
+	}
+
+	return mime.QEncoding.Encode("utf-8", value)
+}
+
+func DecodeMetadataValue(value string) string {
+	decoded, err := new(mime.WordDecoder).DecodeHeader(value)
+	if err != nil {
+		return value
+	}
+
+	return decoded
+}
+
+func (v IExifValue) Rational() (float64, string, bool) {
+	if (v.kind != 5 && v.kind != 10) || len(v.values) < 8 {
+		return 0, "", false
+	}
+
+	numerator := int64(v.order.Uint32(v.values))
+	denominator := int64(v.order.Uint32(v.values[4:]))
+	if v.kind == 10 {
+		numerator = int64(int32(numerator))
+		denominator = int64(int32(denominator))
+	}
+
+	if denominator == 0 {
+		return 0, "", false
+	}
+
+	return float64(numerator) / float64(denominator), fmt.Sprintf("%d/%d", numerator, denominator), true
+}
+
+func exifMediaValue(value IExifValue) string {
+	if text := value.String(); text != "" {
+		return text
+	}
+
+	if number, fraction, ok := value.Rational(); ok {
+		if number < 1 && number > 0 {
+			return fraction
+		}
+
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	if number, ok := value.Int(); ok {
+		return strconv.FormatInt(number, 10)
+	}
+
+	return ""
+}
+
+func extractMp3(data []byte, media map[string]string) {
+	offset := 0
+
+	if bytes.HasPrefix(data, []byte("ID3")) && len(data) >= 10 {
+		version := data[3]
+		size := syncsafe(data[6:10])
+		end := min(10+size, len(data))
+
+		frame := 10
+		for frame+10 <= end {
+			id := string(data[frame : frame+4])
+			frameSize := int(binary.BigEndian.Uint32(data[frame+4:]))
+			if version >= 4 {
+				frameSize = syncsafe(data[frame+4 : frame+8])
+			}
+
+			if id[0] == 0 || frameSize <= 0 || frame+10+frameSize > end {
+				break
+			}
+
+			if name, ok := id3MediaFrames[id]; ok {
+				if text := id3Text(data[frame+10 : frame+10+frameSize]); text != "" {
+					media[name] = text
+				}
+			}
+
+			frame += 10 + frameSize
+		}
+
+		offset = end
+	}
+
+	for offset+4 <= len(data) && !isMpegFrame(data[offset:]) {
+		offset++
+	}
+
+	if offset+4 > len(data) {
+		return
+	}
+
+	bitrate, sampleRate, samplesPerFrame := mpegFrameInfo(data[offset:])
+	if sampleRate == 0 {
+		return
+	}
+
+	media["sample-rate"] = strconv.Itoa(sampleRate)
+
+	// VBR files carry the number of frames in a Xing/Info header inside the first
+	// frame; CBR files are estimated from the bitrate.
+	var duration float64
+	if index := bytes.Index(data[offset:min(offset+200, len(data))], []byte("Xing")); index < 0 {
+		index = bytes.Index(data[offset:min(offset+200, len(data))], []byte("Info"))
+		if index >= 0 {
+			duration = xingDuration(data[offset+index:], sampleRate, samplesPerFrame)
+		}
+	} else {
+		duration = xingDuration(data[offset+index:], sampleRate, samplesPerFrame)
+	}
+
+	if duration == 0 && bitrate > 0 {
+		duration = float64(len(data)-offset) * 8 / float64(bitrate)
+	}
+
+	if duration > 0 {
+		media["duration"] = strconv.FormatFloat(duration, 'f', 2, 64)
+	}
+	if bitrate > 0 {
+		media["bitrate"] = strconv.Itoa(bitrate)
+	}
+}
+
+func isMpegFrame(data []byte) bool {
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return false
+	}
+
+	_, sampleRate, _ := mpegFrameInfo(data)
+	return sampleRate > 0
+}
+
+func mpegFrameInfo(header []byte) (int, int, int) {
+	version := (header[1] >> 3) & 0x03
+	layer := (header[1] >> 1) & 0x03
+	bitrateIndex := header[2] >> 4
+	sampleRateIndex := (header[2] >> 2) & 0x03
+
+	if version == 1 || layer == 0 || bitrateIndex == 0x0F || sampleRateIndex == 3 {
+		return 0, 0, 0
+	}
+
+	sampleRates := map[byte][3]int{
+		3: {44100, 48000, 32000},
+		2: {22050, 24000, 16000},
+		0: {11025, 12000, 8000},
+	}
+	sampleRate := sampleRates[version][sampleRateIndex]
+
+	isVersion1 := version == 3
+	var bitrates [16]int
+	switch {
+	case isVersion1 && layer == 3:
+		bitrates = [16]int{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448}
+	case isVersion1 && layer == 2:
+		bitrates = [16]int{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384}
+	case isVersion1:
+		bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320}
+	case layer == 3:
+		bitrates = [16]int{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256}
+	default:
+		bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160}
+	}
+
+	samplesPerFrame := 1152
+	if layer == 3 {
+		samplesPerFrame = 384
+	} else if layer == 1 && !isVersion1 {
+		samplesPerFrame = 576
+	}
+
+	return bitrates[bitrateIndex] * 1000, sampleRate, samplesPerFrame
+}
+
+func xingDuration(header []byte, sampleRate int, samplesPerFrame int) float64 {
+	if len(header) < 12 || header[7]&0x01 == 0 {
+		return 0
+	}
+
+	frames := binary.BigEndian.Uint32(header[8:])
+	return float64(frames) * float64(samplesPerFrame) / float64(sampleRate)
+}
+
+func syncsafe(data []byte) int {
+	return int(data[0]&0x7F)<<21 | int(data[1]&0x7F)<<14 | int(data[2]&0x7F)<<7 | int(data[3]&0x7F)
+}
+
+func id3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+
+	text := frame[1:]
+	switch frame[0] {
+	case 0:
+		runes := make([]rune, len(text))
+		for index, character := range text {
+			runes[index] = rune(character)
+		}
+		return strings.TrimRight(string(runes), "\x00 ")
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if len(text) >= 2 && text[0] == 0xFF && text[1] == 0xFE {
+			order, text = binary.LittleEndian, text[2:]
+		} else if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+			text = text[2:]
+		}
+
+		units := make([]uint16, 0, len(text)/2)
+		for index := 0; index+1 < len(text); index += 2 {
+			units = append(units, order.Uint16(text[index:]))
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00 ")
+	default:
+		return strings.TrimRight(string(text), "\x00 ")
+	}
+}
+
+func extractMp4(data []byte, media map[string]string) {
+	moov := mp4Atom(data, "moov")
+	if moov == nil {
+		return
+	}
+
+	if mvhd := mp4Atom(moov, "mvhd"); len(mvhd) >= 20 {
+		var timescale, duration uint64
+		if mvhd[0] == 1 && len(mvhd) >= 32 {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[20:]))
+			duration = binary.BigEndian.Uint64(mvhd[24:])
+		} else {
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[12:]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+		}
+
+		if timescale > 0 {
+			media["duration"] = strconv.FormatFloat(float64(duration)/float64(timescale), 'f', 2, 64)
+		}
+	}
+
+	for trak := range mp4Atoms(moov, "trak") {
+		tkhd := mp4Atom(trak, "tkhd")
+		if len(tkhd) < 84 {
+			continue
+		}
+
+		width := binary.BigEndian.Uint32(tkhd[len(tkhd)-8:]) >> 16
+		height := binary.BigEndian.Uint32(tkhd[len(tkhd)-4:]) >> 16
+		if width > 0 && height > 0 {
+			media["width"] = strconv.Itoa(int(width))
+			media["height"] = strconv.Itoa(int(height))
+			break
+		}
+	}
+}
+
+// mp4Atom returns the payload of the first child atom of the given type.
+func mp4Atom(data []byte, kind string) []byte {
+	for atom := range mp4Atoms(data, kind) {
+		return atom
+	}
+
+	return nil
+}
+
+// mp4Atoms yields the payload of every child atom of the given type.
+func mp4Atoms(data []byte, kind string) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		offset := 0
+		for offset+8 <= len(data) {
+			size := uint64(binary.BigEndian.Uint32(data[offset:]))
+			header := uint64(8)
+
+			if size == 1 && offset+16 <= len(data) {
+				size = binary.BigEndian.Uint64(data[offset+8:])
+				header = 16
+			} else if size == 0 {
+				size = uint64(len(data) - offset)
+			}
+
+			if size < header || uint64(offset)+size > uint64(len(data)) {
+				return
+			}
+
+			if string(data[offset+4:offset+8]) == kind {
+				if !yield(data[uint64(offset)+header : uint64(offset)+size]) {
+					return
+				}
+			}
+
+			offset += int(size)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	DefaultPreviewBytes = 4096
+	MaxPreviewBytes     = 1 << 20
+)
+
+type IPreview struct {
+	Filename  string `json:"filename"`
+	Size      int64  `json:"size"`
+	Offset    int64  `json:"offset"`
+	Length    int    `json:"length"`
+	Truncated bool   `json:"truncated"`
+	Binary    bool   `json:"binary"`
+	Charset   string `json:"charset,omitempty"`
+	Content   string `json:"content"`
+}
+
+type IPreviewService struct {
+	dedupe *IDedupeService
+}
+
+func PreviewService(storage *ICloudflareService) *IPreviewService {
+	return &IPreviewService{
+		dedupe: DedupeService(storage),
+	}
+}
+
+// Preview reads at most maxBytes from the start of the file, or from its end
+// when tail is true, and returns them as text or as a hex dump for binary
+// content. A positive lines limits the text to the first (or last) lines.
+func (s *IPreviewService) Preview(filename string, maxBytes int, lines int, tail bool) (*IPreview, error) {
+	byteRange := fmt.Sprintf("bytes=0-%d", maxBytes-1)
+	if tail {
+		byteRange = fmt.Sprintf("bytes=-%d", maxBytes)
+	}
+
+	file, size, err := s.dedupe.GetFileRange(filename, byteRange)
+	if err != nil {
+		return nil, err
+	}
+
+	preview := &IPreview{
+		Filename: filename,
+		Size:     size,
+	}
+
+	if file == nil {
+		return preview, nil
+	}
+	defer file.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file.Body, int64(maxBytes)))
+	if err != nil {
+		return nil, err
+	}
+
+	if tail {
+		preview.Offset = size - int64(len(data))
+	}
+	preview.Length = len(data)
+	preview.Truncated = int64(len(data)) < size
+
+	text, charset, isText := decodeText(data, preview.Offset, preview.Truncated && !tail)
+	if !isText {
+		preview.Binary = true
+		preview.Content = hexDump(data, preview.Offset)
+		return preview, nil
+	}
+
+	preview.Charset = charset
+	preview.Content = limitLines(text, lines, tail, preview.Offset > 0)
+
+	return preview, nil
+}
+
+// decodeText detects the charset of data and returns it decoded as UTF-8. Data
+// that looks binary is reported with isText false.
+func decodeText(data []byte, offset int64, truncated bool) (string, string, bool) {
+	switch {
+	case offset == 0 && bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(bytes.ToValidUTF8(data[3:], []byte("�"))), "utf-8", true
+	case offset == 0 && (bytes.HasPrefix(data, []byte{0xFF, 0xFE}) || bytes.HasPrefix(data, []byte{0xFE, 0xFF})):
+		decoded, err := unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder().Bytes(data)
+		if err == nil {
+			return string(decoded), "utf-16", true
+		}
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		return "", "", false
+	}
+
+	control := 0
+	for _, character := range data {
+		if character < 0x20 && character != '\n' && character != '\r' && character != '\t' && character != '\f' && character != 0x1B {
+			control++
+		}
+	}
+	if len(data) > 0 && control*10 > len(data) {
+		return "", "", false
+	}
+
+	valid := data
+	if truncated {
+		for trim := 0; trim < utf8.UTFMax && len(valid) > 0 && !utf8.Valid(valid); trim++ {
+			valid = valid[:len(valid)-1]
+		}
+	}
+	if offset > 0 {
+		for len(valid) > 0 && !utf8.RuneStart(valid[0]) {
+			valid = valid[1:]
+		}
+	}
+
+	if utf8.Valid(valid) {
+		if isASCII(valid) {
+			return string(valid), "us-ascii", true
+		}
+		return string(valid), "utf-8", true
+	}
+
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", "", false
+	}
+
+	return string(decoded), "windows-1252", true
+}
+
+func limitLines(text string, lines int, tail bool, partialStart bool) string {
+	if lines <= 0 {
+		return text
+	}
+
+	if !tail {
+		parts := strings.SplitAfterN(text, "\n", lines+1)
+		if len(parts) > lines {
+			parts = parts[:lines]
+		}
+		return strings.Join(parts, "")
+	}
+
+	trimmed := strings.TrimSuffix(text, "\n")
+	parts := strings.SplitAfter(trimmed, "\n")
+	if partialStart && len(parts) > 1 {
+		parts = parts[1:]
+	}
+	if len(parts) > lines {
+		parts = parts[len(parts)-lines:]
+	}
+
+	return strings.Join(parts, "") + text[len(trimmed):]
+}
+
+func hexDump(data []byte, offset int64) string {
+	var dump strings.Builder
+
+	for start := 0; start < len(data); start += 16 {
+		end := min(start+16, len(data))
+		line := data[start:end]
+
+		fmt.Fprintf(&dump, "%08x  ", offset+int64(start))
+		for index := 0; index < 16; index++ {
+			if index < len(line) {
+				fmt.Fprintf(&dump, "%02x ", line[index])
+			} else {
+				dump.WriteString("   ")
+			}
+			if index == 7 {
+				dump.WriteByte(' ')
+			}
+		}
+
+		dump.WriteString(" |")
+		for _, character := range line {
+			if character >= 0x20 && character < 0x7F {
+				dump.WriteByte(character)
+			} else {
+				dump.WriteByte('.')
+			}
+		}
+		dump.WriteString("|\n")
+	}
+
+	return dump.String()
+}
+
+func isASCII(data []byte) bool {
+	for _, character := range data {
+		if character >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,344 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	QueryFormatCsv    = "csv"
+	QueryFormatJsonl  = "jsonl"
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 10000
+	maxQueryLineSize  = 4 << 20
+)
+
+var (
+	ErrInvalidQuery   = errors.New("Invalid query")
+	ErrInvalidContent = errors.New("Invalid file content")
+)
+
+// Operators are checked in order so the two-character ones win over their prefixes.
+var queryOperators = []string{">=", "<=", "!=", "=", ">", "<", "~"}
+
+type IQueryCondition struct {
+	Column   string
+	Operator string
+	Value    string
+}
+
+type IQueryOptions struct {
+	Format    string
+	Select    []string
+	Where     []IQueryCondition
+	Limit     int
+	Delimiter rune
+	NoHeader  bool
+}
+
+type IQueryResult struct {
+	Columns   []string         `json:"columns"`
+	Rows      []map[string]any `json:"rows"`
+	Scanned   int              `json:"scanned"`
+	Returned  int              `json:"returned"`
+	Truncated bool             `json:"truncated"`
+}
+
+type IQueryService struct {
+	dedupe *IDedupeService
+}
+
+func QueryService(storage *ICloudflareService) *IQueryService {
+	return &IQueryService{
+		dedupe: DedupeService(storage),
+	}
+}
+
+// QueryFormatByExtension returns the query format matching the file extension,
+// or an empty string when it is not a supported format.
+func QueryFormatByExtension(filename string) string {
+	switch strings.ToLower(filename[strings.LastIndex(filename, ".")+1:]) {
+	case "csv", "tsv":
+		return QueryFormatCsv
+	case "jsonl", "ndjson":
+		return QueryFormatJsonl
+	}
+
+	return ""
+}
+
+// ParseQueryCondition parses expressions such as "age>=30", "country=ES" or
+// "name~ana" (contains).
+func ParseQueryCondition(expression string) (IQueryCondition, error) {
+	position, operator := -1, ""
+	for _, candidate := range queryOperators {
+		index := strings.Index(expression, candidate)
+		if index >= 0 && (position == -1 || index < position || (index == position && len(candidate) > len(operator))) {
+			position, operator = index, candidate
+		}
+	}
+
+	if position == -1 || strings.TrimSpace(expression[:position]) == "" {
+		return IQueryCondition{}, fmt.Errorf("%w: condition '%s' must be <column><operator><value>", ErrInvalidQuery, expression)
+	}
+
+	return IQueryCondition{
+		Column:   strings.TrimSpace(expression[:position]),
+		Operator: operator,
+		Value:    strings.TrimSpace(expression[position+len(operator):]),
+	}, nil
+}
+
+func (c IQueryCondition) Matches(value string) bool {
+	if c.Operator == "~" {
+		return strings.Contains(strings.ToLower(value), strings.ToLower(c.Value))
+	}
+
+	comparison := strings.Compare(value, c.Value)
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		if expected, errExpected := strconv.ParseFloat(c.Value, 64); errExpected == nil {
+			comparison = compareNumbers(number, expected)
+		}
+	}
+
+	switch c.Operator {
+	case "=":
+		return comparison == 0
+	case "!=":
+		return comparison != 0
+	case ">":
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	}
+
+	return false
+}
+
+// Query streams the file through a CSV or JSON Lines parser and returns the
+// rows matching every condition, projected to the selected columns. Reading
+// stops as soon as the limit is reached.
+func (s *IQueryService) Query(filename string, options IQueryOptions) (*IQueryResult, error) {
+	file, err := s.dedupe.GetFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Body.Close()
+
+	result := &IQueryResult{
+		Columns: options.Select,
+		Rows:    make([]map[string]any, 0),
+	}
+
+	add := func(row map[string]any, lookup func(column string) (any, bool)) bool {
+		result.Scanned++
+
+		for _, condition := range options.Where {
+			current, ok := lookup(condition.Column)
+			if !ok || !condition.Matches(queryText(current)) {
+				return true
+			}
+		}
+
+		if result.Returned == options.Limit {
+			result.Truncated = true
+			return false
+		}
+
+		if len(options.Select) > 0 {
+			projected := make(map[string]any, len(options.Select))
+			for _, column := range options.Select {
+				projected[column], _ = lookup(column)
+			}
+			row = projected
+		}
+
+		result.Rows = append(result.Rows, row)
+		result.Returned++
+
+		return true
+	}
+
+	switch options.Format {
+	case QueryFormatCsv:
+		err = queryCsv(file.Body, options, result, add)
+	case QueryFormatJsonl:
+		err = queryJsonl(file.Body, add)
+	default:
+		err = fmt.Errorf("%w: format must be one of: csv, jsonl", ErrInvalidQuery)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func queryCsv(reader io.Reader, options IQueryOptions, result *IQueryResult, add func(map[string]any, func(string) (any, bool)) bool) error {
+	parser := csv.NewReader(reader)
+	parser.FieldsPerRecord = -1
+	parser.ReuseRecord = true
+	if options.Delimiter != 0 {
+		parser.Comma = options.Delimiter
+	}
+
+	var header []string
+	for {
+		record, err := parser.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidContent, err.Error())
+		}
+
+		if header == nil {
+			if options.NoHeader {
+				header = make([]string, len(record))
+				for index := range record {
+					header[index] = "_" + strconv.Itoa(index+1)
+				}
+			} else {
+				header = append([]string(nil), record...)
+				header[0] = strings.TrimPrefix(header[0], "\ufeff")
+			}
+
+			if err = checkColumns(header, options); err != nil {
+				return err
+			}
+
+			if len(result.Columns) == 0 {
+				result.Columns = header
+			}
+
+			if !options.NoHeader {
+				continue
+			}
+		}
+
+		row := make(map[string]any, len(header))
+		for index, column := range header {
+			if index < len(record) {
+				row[column] = record[index]
+			} else {
+				row[column] = ""
+			}
+		}
+
+		if !add(row, func(column string) (any, bool) {
+			value, ok := row[column]
+			return value, ok
+		}) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func queryJsonl(reader io.Reader, add func(map[string]any, func(string) (any, bool)) bool) error {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxQueryLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		content := strings.TrimSpace(scanner.Text())
+		if content == "" {
+			continue
+		}
+
+		var row map[string]any
+		if err := json.Unmarshal([]byte(content), &row); err != nil {
+			return fmt.Errorf("%w: line %d is not a JSON object", ErrInvalidContent, line)
+		}
+
+		if !add(row, func(column string) (any, bool) {
+			return jsonValue(row, column)
+		}) {
+			return nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidContent, err.Error())
+	}
+
+	return nil
+}
+
+func checkColumns(header []string, options IQueryOptions) error {
+	columns := make(map[string]bool, len(header))
+	for _, column := range header {
+		columns[column] = true
+	}
+
+	for _, column := range options.Select {
+		if !columns[column] {
+			return fmt.Errorf("%w: unknown column '%s'", ErrInvalidQuery, column)
+		}
+	}
+
+	for _, condition := range options.Where {
+		if !columns[condition.Column] {
+			return fmt.Errorf("%w: unknown column '%s'", ErrInvalidQuery, condition.Column)
+		}
+	}
+
+	return nil
+}
+
+// jsonValue resolves a column, which may be a dotted path into nested objects.
+func jsonValue(row map[string]any, column string) (any, bool) {
+	var value any = row
+	for _, key := range strings.Split(column, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+
+		if value, ok = object[key]; !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+func queryText(value any) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(typed)
+	default:
+		encoded, _ := json.Marshal(typed)
+		return string(encoded)
+	}
+}
+
+func compareNumbers(a float64, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseQueryCondition(t *testing.T) {
+	tests := []struct {
+		expression string
+		column     string
+		operator   string
+		value      string
+	}{
+		{expression: "country=ES", column: "country", operator: "=", value: "ES"},
+		{expression: "age>=30", column: "age", operator: ">=", value: "30"},
+		{expression: "age<=30", column: "age", operator: "<=", value: "30"},
+		{expression: "age>30", column: "age", operator: ">", value: "30"},
+		{expression: "age<30", column: "age", operator: "<", value: "30"},
+		{expression: "status!=done", column: "status", operator: "!=", value: "done"},
+		{expression: "name~ana", column: "name", operator: "~", value: "ana"},
+		{expression: " city = Madrid ", column: "city", operator: "=", value: "Madrid"},
+		{expression: "url=https://example.com/?a=1", column: "url", operator: "=", value: "https://example.com/?a=1"},
+		{expression: "a>=b=c", column: "a", operator: ">=", value: "b=c"},
+		{expression: "note~x>=y", column: "note", operator: "~", value: "x>=y"},
+		{expression: "empty=", column: "empty", operator: "=", value: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expression, func(t *testing.T) {
+			condition, err := ParseQueryCondition(test.expression)
+			if err != nil {
+				t.Fatalf("ParseQueryCondition() error = %v", err)
+			}
+
+			if condition.Column != test.column || condition.Operator != test.operator || condition.Value != test.value {
+				t.Fatalf("ParseQueryCondition() = %+v, want %s %s %q", condition, test.column, test.operator, test.value)
+			}
+		})
+	}
+}
+
+func TestParseQueryConditionInvalid(t *testing.T) {
+	for _, expression := range []string{"", "country", "=ES", ">=30", "~ana", " =ES", "!=ES"} {
+		t.Run(expression, func(t *testing.T) {
+			if _, err := ParseQueryCondition(expression); !errors.Is(err, ErrInvalidQuery) {
+				t.Fatalf("ParseQueryCondition() error = %v, want %v", err, ErrInvalidQuery)
+			}
+		})
+	}
+}
+
+func TestQueryConditionMatches(t *testing.T) {
+	tests := []struct {
+		expression string
+		value      string
+		matches    bool
+	}{
+		{expression: "age>=30", value: "30", matches: true},
+		{expression: "age>=30", value: "29.5", matches: false},
+		{expression: "age>9", value: "10", matches: true},
+		{expression: "age<9", value: "10", matches: false},
+		{expression: "age=30", value: "30.0", matches: true},
+		{expression: "name>b", value: "c", matches: true},
+		{expression: "name>9", value: "a", matches: true},
+		{expression: "country=ES", value: "es", matches: false},
+		{expression: "country!=ES", value: "FR", matches: true},
+		{expression: "name~ANA", value: "Mariana", matches: true},
+		{expression: "name~ana", value: "Maria", matches: false},
+	}
+
+	for _, test := range tests {
+		condition, err := ParseQueryCondition(test.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if matches := condition.Matches(test.value); matches != test.matches {
+			t.Fatalf("%s matches %q = %v, want %v", test.expression, test.value, matches, test.matches)
+		}
+	}
+}
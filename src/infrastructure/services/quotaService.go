@@ -0,0 +1,274 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"sort"
+	"storage-api/src/domain"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrQuotaExceeded = errors.New("Storage quota exceeded")
+
+type IQuotaUsage struct {
+	Folder     string `json:"folder"`
+	Bytes      int64  `json:"bytes"`
+	Objects    int64  `json:"objects"`
+	MaxBytes   int64  `json:"maxBytes,omitempty"`
+	MaxObjects int64  `json:"maxObjects,omitempty"`
+}
+
+type IQuotaReport struct {
+	Folders      []IQuotaUsage `json:"folders"`
+	ReconciledAt time.Time     `json:"reconciledAt"`
+}
+
+// quotaUsage keeps the usage of every top-level folder. It is updated on every
+// upload and delete, and replaced by the real listing on each reconciliation.
+// While the bucket is listed, the updates are also kept in changes and added to
+// the result, as the listing may have been taken before they were made.
+var quotaUsage = struct {
+	sync.Mutex
+	folders      map[string]*IQuotaUsage
+	changes      map[string]*IQuotaUsage
+	reconciledAt time.Time
+}{
+	folders: make(map[string]*IQuotaUsage),
+}
+
+// quotaReconcile runs one reconciliation at a time, as each one owns changes.
+var quotaReconcile sync.Mutex
+
+type IQuotaService struct {
+	storage *ICloudflareService
+}
+
+func QuotaService(storage *ICloudflareService) *IQuotaService {
+	return &IQuotaService{
+		storage: storage,
+	}
+}
+
+func QuotasEnabled() bool {
+	return len(domain.CONFIG.Quotas) > 0
+}
+
+// QuotaFolder returns the top-level folder of a key, or an empty string for
+// files in the root and in the hidden prefixes, which are not accounted.
+func QuotaFolder(key string) string {
+	folder, _, found := strings.Cut(strings.TrimPrefix(key, "/"), "/")
+	if !found || strings.HasPrefix(folder, ".") {
+		return ""
+	}
+
+	return folder
+}
+
+// StoredSize returns the bytes an object takes, following dedupe references.
+func StoredSize(head *r2.HeadObjectOutput) int64 {
+	if size, err := strconv.ParseInt(head.Metadata[BlobSizeMetadata], 10, 64); err == nil {
+		return size
+	}
+
+	if head.ContentLength != nil {
+		return *head.ContentLength
+	}
+
+	return 0
+}
+
+// ReserveQuota adds bytes and objects to the usage of the folder of key, or
+// returns ErrQuotaExceeded when it would go over its quota. A reservation for
+// an upload that fails must be given back with RecordUsage.
+func ReserveQuota(key string, bytes int64, objects int64) error {
+	folder := QuotaFolder(key)
+	if !QuotasEnabled() || folder == "" {
+		return nil
+	}
+
+	quotaUsage.Lock()
+	defer quotaUsage.Unlock()
+
+	usage := folderUsage(folder)
+
+	if quota := domain.CONFIG.Quotas[folder]; quota != nil {
+		if bytes > 0 && quota.MaxBytes > 0 && usage.Bytes+bytes > quota.MaxBytes {
+			return fmt.Errorf("%w for folder '%s': %d of %d bytes used", ErrQuotaExceeded, folder, usage.Bytes, quota.MaxBytes)
+		}
+
+		if objects > 0 && quota.MaxObjects > 0 && usage.Objects+objects > quota.MaxObjects {
+			return fmt.Errorf("%w for folder '%s': %d of %d files used", ErrQuotaExceeded, folder, usage.Objects, quota.MaxObjects)
+		}
+	}
+
+	usage.Bytes += bytes
+	usage.Objects += objects
+	recordChange(folder, bytes, objects)
+
+	return nil
+}
+
+func RecordUsage(key string, bytes int64, objects int64) {
+	folder := QuotaFolder(key)
+	if !QuotasEnabled() || folder == "" {
+		return
+	}
+
+	quotaUsage.Lock()
+	defer quotaUsage.Unlock()
+
+	usage := folderUsage(folder)
+	usage.Bytes = max(0, usage.Bytes+bytes)
+	usage.Objects = max(0, usage.Objects+objects)
+	recordChange(folder, bytes, objects)
+}
+
+// Usage returns the usage of every folder with a quota or with files.
+func Usage() *IQuotaReport {
+	quotaUsage.Lock()
+	defer quotaUsage.Unlock()
+
+	for folder := range domain.CONFIG.Quotas {
+		folderUsage(folder)
+	}
+
+	report := &IQuotaReport{
+		Folders:      make([]IQuotaUsage, 0, len(quotaUsage.folders)),
+		ReconciledAt: quotaUsage.reconciledAt,
+	}
+	for _, usage := range quotaUsage.folders {
+		report.Folders = append(report.Folders, *usage)
+	}
+
+	sort.Slice(report.Folders, func(i, j int) bool {
+		return report.Folders[i].Folder < report.Folders[j].Folder
+	})
+
+	return report
+}
+
+// Reconcile recomputes the usage of every top-level folder from the listing of
+// the bucket. Empty objects are read to find the size of dedupe references.
+// Uploads and deletes made meanwhile are added to the result; one made while
+// its page was listed may be counted twice until the next reconciliation.
+func (s *IQuotaService) Reconcile() error {
+	quotaReconcile.Lock()
+	defer quotaReconcile.Unlock()
+
+	quotaUsage.Lock()
+	quotaUsage.changes = make(map[string]*IQuotaUsage)
+	quotaUsage.Unlock()
+
+	objects, err := s.storage.GetAllFiles("")
+	if err != nil {
+		quotaUsage.Lock()
+		quotaUsage.changes = nil
+		quotaUsage.Unlock()
+
+		return err
+	}
+
+	folders := make(map[string]*IQuotaUsage)
+	for _, object := range objects {
+		folder := QuotaFolder(*object.Key)
+		if folder == "" {
+			continue
+		}
+
+		usage := folders[folder]
+		if usage == nil {
+			usage = &IQuotaUsage{Folder: folder}
+			folders[folder] = usage
+		}
+
+		size := *object.Size
+		if size == 0 {
+			if head, errHead := s.storage.HeadFile(*object.Key); errHead == nil {
+				size = StoredSize(head)
+			}
+		}
+
+		usage.Bytes += size
+		usage.Objects++
+	}
+
+	quotaUsage.Lock()
+	defer quotaUsage.Unlock()
+
+	for folder, change := range quotaUsage.changes {
+		usage := folders[folder]
+		if usage == nil {
+			usage = &IQuotaUsage{Folder: folder}
+			folders[folder] = usage
+		}
+
+		usage.Bytes = max(0, usage.Bytes+change.Bytes)
+		usage.Objects = max(0, usage.Objects+change.Objects)
+	}
+
+	quotaUsage.folders = folders
+	quotaUsage.changes = nil
+	quotaUsage.reconciledAt = time.Now()
+	for folder := range folders {
+		folderUsage(folder)
+	}
+
+	return nil
+}
+
+func QuotaCollector() {
+	go func() {
+		storage := CloudflareService()
+		if storage == nil {
+			return
+		}
+
+		quotas := QuotaService(storage)
+
+		for {
+			if err := quotas.Reconcile(); err != nil {
+				domain.Logger.Error("Error reconciling quota usage: " + err.Error())
+			}
+
+			time.Sleep(time.Duration(domain.CONFIG.QuotaReconcileInterval) * time.Minute)
+		}
+	}()
+}
+
+// recordChange keeps an update made while a reconciliation lists the bucket.
+// The caller must hold the lock.
+func recordChange(folder string, bytes int64, objects int64) {
+	if quotaUsage.changes == nil {
+		return
+	}
+
+	change := quotaUsage.changes[folder]
+	if change == nil {
+		change = &IQuotaUsage{Folder: folder}
+		quotaUsage.changes[folder] = change
+	}
+
+	change.Bytes += bytes
+	change.Objects += objects
+}
+
+// folderUsage returns the counters of folder with its quota, creating them.
+// The caller must hold the lock.
+func folderUsage(folder string) *IQuotaUsage {
+	usage := quotaUsage.folders[folder]
+	if usage == nil {
+		usage = &IQuotaUsage{Folder: folder}
+		quotaUsage.folders[folder] = usage
+	}
+
+	if quota := domain.CONFIG.Quotas[folder]; quota != nil {
+		usage.MaxBytes = quota.MaxBytes
+		usage.MaxObjects = quota.MaxObjects
+	}
+
+	return usage
+}
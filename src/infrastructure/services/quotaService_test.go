@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"storage-api/src/domain"
+	"sync"
+	"testing"
+)
+
+// withQuotas configures the quota of docs and starts with no usage.
+func withQuotas(t *testing.T) {
+	t.Helper()
+
+	config := domain.CONFIG
+	domain.CONFIG = &domain.IConfig{
+		Quotas: map[string]*domain.IQuota{
+			"docs": {Folder: "docs", MaxBytes: 100, MaxObjects: 2},
+		},
+	}
+	quotaUsage.folders = make(map[string]*IQuotaUsage)
+	quotaUsage.changes = nil
+
+	t.Cleanup(func() {
+		domain.CONFIG = config
+		quotaUsage.folders = make(map[string]*IQuotaUsage)
+		quotaUsage.changes = nil
+	})
+}
+
+func TestReserveQuota(t *testing.T) {
+	withQuotas(t)
+
+	if err := ReserveQuota("docs/a.pdf", 60, 1); err != nil {
+		t.Fatalf("ReserveQuota() error = %v", err)
+	}
+
+	if err := ReserveQuota("docs/b.pdf", 60, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("ReserveQuota() over the bytes error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	if err := ReserveQuota("docs/b.pdf", 40, 1); err != nil {
+		t.Fatalf("ReserveQuota() error = %v", err)
+	}
+
+	if err := ReserveQuota("docs/c.pdf", 0, 1); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("ReserveQuota() over the files error = %v, want %v", err, ErrQuotaExceeded)
+	}
+
+	// Replacing a file with a smaller one always fits.
+	if err := ReserveQuota("docs/a.pdf", -10, 0); err != nil {
+		t.Fatalf("ReserveQuota() error = %v", err)
+	}
+
+	// Other folders and hidden prefixes have no quota.
+	for _, key := range []string{"images/a.png", ".trash/1/docs/a.pdf", "root.txt"} {
+		if err := ReserveQuota(key, 1000, 10); err != nil {
+			t.Fatalf("ReserveQuota(%s) error = %v", key, err)
+		}
+	}
+
+	RecordUsage("docs/b.pdf", -40, -1)
+
+	if usage := quotaUsage.folders["docs"]; usage.Bytes != 50 || usage.Objects != 1 {
+		t.Fatalf("usage = %d bytes and %d files, want 50 and 1", usage.Bytes, usage.Objects)
+	}
+}
+
+func TestReserveQuotaConcurrent(t *testing.T) {
+	withQuotas(t)
+
+	var group sync.WaitGroup
+	var reserved sync.Map
+	for index := 0; index < 20; index++ {
+		group.Add(1)
+		go func(index int) {
+			defer group.Done()
+			if ReserveQuota("docs/file.pdf", 10, 0) == nil {
+				reserved.Store(index, true)
+			}
+		}(index)
+	}
+	group.Wait()
+
+	count := 0
+	reserved.Range(func(any, any) bool {
+		count++
+		return true
+	})
+
+	if count != 10 || quotaUsage.folders["docs"].Bytes != 100 {
+		t.Fatalf("%d reservations for %d bytes, want 10 for 100", count, quotaUsage.folders["docs"].Bytes)
+	}
+}
+
+func TestQuotaChangesDuringReconcile(t *testing.T) {
+	withQuotas(t)
+
+	if err := ReserveQuota("docs/before.pdf", 5, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A reconciliation starts listing the bucket.
+	quotaUsage.changes = make(map[string]*IQuotaUsage)
+
+	if err := ReserveQuota("docs/during.pdf", 30, 1); err != nil {
+		t.Fatal(err)
+	}
+	RecordUsage("docs/before.pdf", -5, -1)
+
+	change := quotaUsage.changes["docs"]
+	if change == nil || change.Bytes != 25 || change.Objects != 0 {
+		t.Fatalf("changes = %+v, want 25 bytes and 0 files", change)
+	}
+}
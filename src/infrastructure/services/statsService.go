@@ -0,0 +1,199 @@
+package services
+
+import (
+	"path/filepath"
+	"storage-api/src/domain"
+	"strings"
+	"sync"
+	"time"
+)
+
+type IStatsGroup struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// IStats describes the files of the bucket. The hidden prefixes (trash,
+// versions, dedupe blobs, ...) are only counted in Internal, so deduplicated
+// files are counted once, with the size of their content.
+type IStats struct {
+	Files       int64                  `json:"files"`
+	Bytes       int64                  `json:"bytes"`
+	Folders     map[string]IStatsGroup `json:"folders"`
+	Extensions  map[string]IStatsGroup `json:"extensions"`
+	Sizes       map[string]IStatsGroup `json:"sizes"`
+	Ages        map[string]IStatsGroup `json:"ages"`
+	Internal    map[string]IStatsGroup `json:"internal"`
+	GeneratedAt time.Time              `json:"generatedAt"`
+}
+
+type statsBucket struct {
+	label string
+	limit int64
+}
+
+var sizeBuckets = []statsBucket{
+	{"0-1KB", 1 << 10},
+	{"1KB-1MB", 1 << 20},
+	{"1MB-10MB", 10 << 20},
+	{"10MB-100MB", 100 << 20},
+	{"100MB-1GB", 1 << 30},
+	{"1GB+", -1},
+}
+
+var ageBuckets = []statsBucket{
+	{"0-1d", 1},
+	{"1d-7d", 7},
+	{"7d-30d", 30},
+	{"30d-90d", 90},
+	{"90d-365d", 365},
+	{"365d+", -1},
+}
+
+// statsCache keeps the last statistics, as computing them lists the whole bucket.
+// Computing is closed when the running computation finishes, with err set if it
+// failed.
+var statsCache = struct {
+	sync.Mutex
+	stats     *IStats
+	expiresAt time.Time
+	computing chan struct{}
+	err       error
+}{}
+
+type IStatsService struct {
+	storage *ICloudflareService
+}
+
+func StatsService(storage *ICloudflareService) *IStatsService {
+	return &IStatsService{
+		storage: storage,
+	}
+}
+
+// GetStats returns the cached statistics while they are newer than
+// STATS_CACHE_TTL, and computes them again otherwise or when refresh is set.
+// Only one computation runs at a time: meanwhile, other requests get the cached
+// statistics, or wait for it when there are none yet.
+func (s *IStatsService) GetStats(refresh bool) (*IStats, error) {
+	statsCache.Lock()
+	cached, computing := statsCache.stats, statsCache.computing
+
+	if cached != nil && (computing != nil || !refresh && time.Now().Before(statsCache.expiresAt)) {
+		statsCache.Unlock()
+		return cached, nil
+	}
+
+	if computing != nil {
+		statsCache.Unlock()
+		<-computing
+
+		statsCache.Lock()
+		defer statsCache.Unlock()
+
+		if statsCache.stats == nil {
+			return nil, statsCache.err
+		}
+
+		return statsCache.stats, nil
+	}
+
+	computing = make(chan struct{})
+	statsCache.computing = computing
+	statsCache.Unlock()
+
+	stats, err := s.computeStats()
+
+	statsCache.Lock()
+	defer statsCache.Unlock()
+
+	if err == nil {
+		statsCache.stats = stats
+		statsCache.expiresAt = stats.GeneratedAt.Add(time.Duration(domain.CONFIG.StatsCacheTtl) * time.Second)
+	}
+	statsCache.err = err
+	statsCache.computing = nil
+	close(computing)
+
+	return stats, err
+}
+
+func (s *IStatsService) computeStats() (*IStats, error) {
+	objects, err := s.storage.GetAllFiles("")
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &IStats{
+		Folders:     make(map[string]IStatsGroup),
+		Extensions:  make(map[string]IStatsGroup),
+		Sizes:       make(map[string]IStatsGroup),
+		Ages:        make(map[string]IStatsGroup),
+		Internal:    make(map[string]IStatsGroup),
+		GeneratedAt: time.Now(),
+	}
+
+	for _, object := range objects {
+		key := *object.Key
+
+		var size int64
+		if object.Size != nil {
+			size = *object.Size
+		}
+
+		folder := "/"
+		if index := strings.Index(key, "/"); index > 0 {
+			folder = key[:index]
+		}
+
+		if strings.HasPrefix(folder, ".") {
+			addStats(stats.Internal, folder, size)
+			continue
+		}
+
+		// Dedupe references are empty objects pointing to the blob with the content.
+		if size == 0 {
+			if head, errHead := s.storage.HeadFile(key); errHead == nil {
+				size = StoredSize(head)
+			}
+		}
+
+		extension := strings.ToLower(strings.TrimPrefix(filepath.Ext(key[strings.LastIndex(key, "/")+1:]), "."))
+		if extension == "" {
+			extension = "(none)"
+		}
+
+		age := ageBuckets[len(ageBuckets)-1].label
+		if object.LastModified != nil {
+			age = bucketLabel(ageBuckets, int64(stats.GeneratedAt.Sub(*object.LastModified)/(24*time.Hour)))
+		}
+
+		stats.Files++
+		stats.Bytes += size
+		addStats(stats.Folders, folder, size)
+		addStats(stats.Extensions, extension, size)
+		addStats(stats.Sizes, bucketLabel(sizeBuckets, size), size)
+		addStats(stats.Ages, age, size)
+	}
+
+	return stats, nil
+}
+
+// bucketLabel returns the first bucket whose limit is above value; the last
+// bucket has no limit.
+func bucketLabel(buckets []statsBucket, value int64) string {
+	for _, bucket := range buckets {
+		if bucket.limit < 0 || value < bucket.limit {
+			return bucket.label
+		}
+	}
+
+	return buckets[len(buckets)-1].label
+}
+
+func addStats(groups map[string]IStatsGroup, name string, size int64) {
+	group := groups[name]
+	group.Files++
+	group.Bytes += size
+	groups[name] = group
+}
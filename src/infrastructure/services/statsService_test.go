@@ -0,0 +1,96 @@
+package services
+
+import (
+	"storage-api/src/domain"
+	"testing"
+	"time"
+)
+
+// withStatsCache starts with no cached statistics and a cache of one minute.
+func withStatsCache(t *testing.T) {
+	t.Helper()
+
+	config := domain.CONFIG
+	domain.CONFIG = &domain.IConfig{StatsCacheTtl: 60}
+
+	reset := func() {
+		statsCache.stats, statsCache.expiresAt, statsCache.computing, statsCache.err = nil, time.Time{}, nil, nil
+	}
+	reset()
+
+	t.Cleanup(func() {
+		domain.CONFIG = config
+		reset()
+	})
+}
+
+func TestGetStats(t *testing.T) {
+	withStatsCache(t)
+
+	storage := testBucket(t, map[string]testObject{
+		"docs/report.pdf":        {body: []byte("report")},
+		"docs/notes.TXT":         {body: []byte("notes")},
+		".trash/1/docs/old.pdf":  {body: []byte("old")},
+		".versions/docs/old.pdf": {body: []byte("older")},
+	})
+
+	stats, err := StatsService(storage).GetStats(false)
+	if err != nil {
+		t.Fatalf("GetStats() error = %v", err)
+	}
+
+	if stats.Files != 2 || stats.Bytes != 11 || stats.Folders["docs"].Files != 2 || stats.Extensions["txt"].Bytes != 5 {
+		t.Fatalf("GetStats() = %+v, want the two files of docs", stats)
+	}
+
+	if stats.Internal[".trash"].Bytes != 3 || stats.Internal[".versions"].Bytes != 5 {
+		t.Fatalf("internal = %+v, want the trash and the versions apart", stats.Internal)
+	}
+
+	if cached, _ := StatsService(storage).GetStats(false); cached != stats {
+		t.Fatal("GetStats() computed the statistics again while they are cached")
+	}
+
+	if refreshed, _ := StatsService(storage).GetStats(true); refreshed == stats {
+		t.Fatal("GetStats() with refresh returned the cached statistics")
+	}
+}
+
+func TestGetStatsWhileComputing(t *testing.T) {
+	withStatsCache(t)
+
+	// A computation is running, so the requests must not list the bucket, which
+	// a service without storage cannot do.
+	computing := make(chan struct{})
+	statsCache.computing = computing
+
+	done := make(chan *IStats)
+	go func() {
+		stats, _ := StatsService(nil).GetStats(false)
+		done <- stats
+	}()
+
+	computed := &IStats{GeneratedAt: time.Now()}
+
+	statsCache.Lock()
+	statsCache.stats = computed
+	statsCache.expiresAt = computed.GeneratedAt.Add(time.Minute)
+	statsCache.computing = nil
+	close(computing)
+	statsCache.Unlock()
+
+	if stats := <-done; stats != computed {
+		t.Fatalf("GetStats() with nothing cached = %v, want the computed statistics", stats)
+	}
+
+	// The cached statistics are returned, even expired or with refresh, while
+	// they are computed again.
+	statsCache.expiresAt = time.Now().Add(-time.Second)
+	statsCache.computing = make(chan struct{})
+
+	for _, refresh := range []bool{false, true} {
+		if stats, err := StatsService(nil).GetStats(refresh); err != nil || stats != computed {
+			t.Fatalf("GetStats(%v) = %v, %v, want the cached statistics", refresh, stats, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"storage-api/src/domain"
+	"strings"
+)
+
+const ThumbnailsPrefix = ".thumbnails/"
+
+type IThumbnailService struct {
+	storage *ICloudflareService
+	dedupe  *IDedupeService
+	sizes   []string
+}
+
+func ThumbnailService(storage *ICloudflareService) *IThumbnailService {
+	return &IThumbnailService{
+		storage: storage,
+		dedupe:  DedupeService(storage),
+		sizes:   domain.CONFIG.ThumbnailSizes,
+	}
+}
+
+// Generate replaces the thumbnails of filename with one per configured size.
+// Files that are not supported images are ignored.
+func (s *IThumbnailService) Generate(filename string, data []byte) (map[string]string, error) {
+	if len(s.sizes) == 0 {
+		return nil, nil
+	}
+
+	img, _, err := DecodeImage(data)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedImage) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if err = s.Delete(filename); err != nil {
+		return nil, err
+	}
+
+	format := imageFormatByExtension(filename)
+
+	thumbnails := make(map[string]string, len(s.sizes))
+	for _, size := range s.sizes {
+		var width, height int
+		if _, err = fmt.Sscanf(size, "%dx%d", &width, &height); err != nil {
+			return nil, err
+		}
+
+		var buffer bytes.Buffer
+		if err = EncodeImage(&buffer, ResizeImage(img, width, height, ImageFitContain), format, DefaultImageQuality); err != nil {
+			return nil, err
+		}
+
+		name := size + "." + format
+		if _, err = s.storage.UploadFile(&buffer, ThumbnailsPrefix+filename, name, ImageContentType(format), nil); err != nil {
+			return nil, err
+		}
+
+		thumbnails[size] = ThumbnailsPrefix + filename + "/" + name
+	}
+
+	return thumbnails, nil
+}
+
+// Regenerate reads filename again and rebuilds its thumbnails.
+func (s *IThumbnailService) Regenerate(filename string) error {
+	if len(s.sizes) == 0 {
+		return nil
+	}
+
+	file, err := s.dedupe.GetFile(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Body.Close()
+
+	if IsEncrypted(file.Metadata) {
+		return nil
+	}
+
+	data, err := io.ReadAll(file.Body)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Generate(filename, data)
+	return err
+}
+
+// GetThumbnails returns the thumbnail keys of every file under prefix, grouped by
+// the key of the original file and the thumbnail size.
+func (s *IThumbnailService) GetThumbnails(prefix string) (map[string]map[string]string, error) {
+	objects, err := s.storage.GetAllFiles(ThumbnailsPrefix + prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbnails := make(map[string]map[string]string)
+	for _, object := range objects {
+		key := strings.TrimPrefix(*object.Key, ThumbnailsPrefix)
+
+		separator := strings.LastIndex(key, "/")
+		if separator < 0 {
+			continue
+		}
+
+		filename, name := key[:separator], key[separator+1:]
+		if thumbnails[filename] == nil {
+			thumbnails[filename] = make(map[string]string)
+		}
+		size, _, _ := strings.Cut(name, ".")
+		thumbnails[filename][size] = *object.Key
+	}
+
+	return thumbnails, nil
+}
+
+func (s *IThumbnailService) Delete(filename string) error {
+	thumbnails, err := s.storage.GetAllFiles(ThumbnailsPrefix + filename + "/")
+	if err != nil {
+		return err
+	}
+
+	for _, thumbnail := range thumbnails {
+		if _, err = s.storage.DeleteFile(*thumbnail.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"storage-api/src/domain"
+	"sync"
+	"time"
+)
+
+const (
+	tokenPrefix          = "sk_"
+	tokenLastUsedRefresh = time.Minute
+)
+
+var (
+	ErrTokenNotFound = errors.New("Token does not exist")
+	ErrInvalidToken  = errors.New("Token is not valid")
+	ErrTokenExpired  = errors.New("Authorization token has expired")
+	ErrTokenRevoked  = errors.New("Authorization token has been revoked")
+	ErrTokenIp       = errors.New("Authorization token is not allowed from this IP")
+
+	tokensBucket      = []byte("tokens")
+	tokenHashesBucket = []byte("token-hashes")
+)
+
+// IApiToken is an API key managed through the API. Like the configured keys,
+// only the SHA-256 of the token is stored.
+type IApiToken struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Hash       string     `json:"-"`
+	Scopes     []string   `json:"scopes"`
+	Prefixes   []string   `json:"prefixes,omitempty"`
+	Cidrs      []string   `json:"cidrs,omitempty"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RotatedAt  *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	LastUsedIp string     `json:"lastUsedIp,omitempty"`
+}
+
+// storedApiToken keeps the hash, which is left out of the API responses.
+type storedApiToken struct {
+	IApiToken
+	Hash string `json:"hash"`
+}
+
+type ITokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Prefixes  []string   `json:"prefixes"`
+	Cidrs     []string   `json:"cidrs"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+type ITokenService struct {
+	db *bolt.DB
+}
+
+var (
+	tokenService     *ITokenService
+	tokenServiceOnce sync.Once
+)
+
+// TokenService opens the token store once and shares it, as the database file
+// can only be opened by one handle. It returns nil when it cannot be opened.
+func TokenService() *ITokenService {
+	tokenServiceOnce.Do(func() {
+		service, err := openTokenStore(domain.CONFIG.TokensDb)
+		if err != nil {
+			domain.Logger.Error("Error opening token store: " + err.Error())
+			return
+		}
+
+		tokenService = service
+	})
+
+	return tokenService
+}
+
+func openTokenStore(path string) (*ITokenService, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, errBucket := tx.CreateBucketIfNotExists(tokensBucket); errBucket != nil {
+			return errBucket
+		}
+
+		_, errBucket := tx.CreateBucketIfNotExists(tokenHashesBucket)
+		return errBucket
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &ITokenService{db: db}, nil
+}
+
+func (t *IApiToken) ApiKey() *domain.IApiKey {
+	return &domain.IApiKey{
+		Name:     t.Name,
+		Hash:     t.Hash,
+		Scopes:   t.Scopes,
+		Prefixes: t.Prefixes,
+	}
+}
+
+// AllowsIp reports whether ip is in one of the token ranges. Tokens without
+// ranges can be used from any IP.
+func (t *IApiToken) AllowsIp(ip string) bool {
+	if len(t.Cidrs) == 0 {
+		return true
+	}
+
+	address := net.ParseIP(ip)
+	if address == nil {
+		return false
+	}
+
+	for _, cidr := range t.Cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(address) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Create stores a new token and returns it with its secret, which is not kept
+// and cannot be recovered.
+func (s *ITokenService) Create(request ITokenRequest) (*IApiToken, string, error) {
+	if err := validateTokenRequest(&request); err != nil {
+		return nil, "", err
+	}
+
+	secret, err := newTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &IApiToken{
+		Id:        uuid.NewString(),
+		Name:      request.Name,
+		Hash:      domain.HashApiKey(secret),
+		Scopes:    request.Scopes,
+		Prefixes:  request.Prefixes,
+		Cidrs:     request.Cidrs,
+		ExpiresAt: request.ExpiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		// Names of revoked tokens can be used again, as they cannot authenticate.
+		errName := tx.Bucket(tokensBucket).ForEach(func(_ []byte, value []byte) error {
+			var stored storedApiToken
+			if errToken := json.Unmarshal(value, &stored); errToken != nil {
+				return errToken
+			}
+
+			if stored.Name == token.Name && stored.RevokedAt == nil {
+				return fmt.Errorf("%w: name '%s' is used by another token", ErrInvalidToken, token.Name)
+			}
+
+			return nil
+		})
+		if errName != nil {
+			return errName
+		}
+
+		if errHash := tx.Bucket(tokenHashesBucket).Put([]byte(token.Hash), []byte(token.Id)); errHash != nil {
+			return errHash
+		}
+
+		return putToken(tx, token)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+func (s *ITokenService) GetTokens() ([]IApiToken, error) {
+	tokens := make([]IApiToken, 0)
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(tokensBucket).ForEach(func(_ []byte, value []byte) error {
+			var stored storedApiToken
+			if err := json.Unmarshal(value, &stored); err != nil {
+				return err
+			}
+
+			tokens = append(tokens, stored.IApiToken)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+
+	return tokens, nil
+}
+
+// Rotate replaces the secret of a token, keeping its permissions. The previous
+// secret stops working immediately.
+func (s *ITokenService) Rotate(id string) (*IApiToken, string, error) {
+	secret, err := newTokenSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var token *IApiToken
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		var errToken error
+		token, errToken = getToken(tx, id)
+		if errToken != nil {
+			return errToken
+		}
+
+		if token.RevokedAt != nil {
+			return ErrTokenRevoked
+		}
+
+		hashes := tx.Bucket(tokenHashesBucket)
+		if errHash := hashes.Delete([]byte(token.Hash)); errHash != nil {
+			return errHash
+		}
+
+		now := time.Now().UTC()
+		token.Hash = domain.HashApiKey(secret)
+		token.RotatedAt = &now
+
+		if errHash := hashes.Put([]byte(token.Hash), []byte(token.Id)); errHash != nil {
+			return errHash
+		}
+
+		return putToken(tx, token)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return token, secret, nil
+}
+
+// Revoke disables a token. It is kept in the store, with its hash, so it still
+// shows in the list and its secret is rejected as revoked.
+func (s *ITokenService) Revoke(id string) (*IApiToken, error) {
+	var token *IApiToken
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var errToken error
+		token, errToken = getToken(tx, id)
+		if errToken != nil {
+			return errToken
+		}
+
+		if token.RevokedAt != nil {
+			return nil
+		}
+
+		now := time.Now().UTC()
+		token.RevokedAt = &now
+
+		return putToken(tx, token)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Authenticate returns the token matching secret when it can be used from ip,
+// and records when and from where it was used. The use is written at most once
+// per minute for each IP, so requests do not wait for the store every time.
+func (s *ITokenService) Authenticate(secret string, ip string) (*IApiToken, error) {
+	hash := domain.HashApiKey(secret)
+
+	var token *IApiToken
+	err := s.db.View(func(tx *bolt.Tx) error {
+		id := tx.Bucket(tokenHashesBucket).Get([]byte(hash))
+		if id == nil {
+			return ErrTokenNotFound
+		}
+
+		var errToken error
+		token, errToken = getToken(tx, string(id))
+		return errToken
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case token.RevokedAt != nil:
+		return nil, ErrTokenRevoked
+	case token.ExpiresAt != nil && now.After(*token.ExpiresAt):
+		return nil, ErrTokenExpired
+	case !token.AllowsIp(ip):
+		return nil, ErrTokenIp
+	}
+
+	if token.LastUsedAt == nil || token.LastUsedIp != ip || now.Sub(*token.LastUsedAt) > tokenLastUsedRefresh {
+		errUse := s.db.Update(func(tx *bolt.Tx) error {
+			current, errToken := getToken(tx, token.Id)
+			if errToken != nil {
+				return errToken
+			}
+
+			current.LastUsedAt = &now
+			current.LastUsedIp = ip
+
+			return putToken(tx, current)
+		})
+		if errUse != nil {
+			domain.Logger.Error("Error recording use of token " + token.Id + ": " + errUse.Error())
+		}
+	}
+
+	return token, nil
+}
+
+// validateTokenRequest checks the request and turns its prefixes into the
+// canonical paths the requests are matched with.
+func validateTokenRequest(request *ITokenRequest) error {
+	if request.Name == "" {
+		return fmt.Errorf("%w: name is missing", ErrInvalidToken)
+	}
+
+	// The name identifies the key in the logs and the scope errors.
+	for _, apiKey := range domain.CONFIG.ApiKeys {
+		if apiKey.Name == request.Name {
+			return fmt.Errorf("%w: name '%s' is used by a configured API key", ErrInvalidToken, request.Name)
+		}
+	}
+
+	if len(request.Scopes) == 0 {
+		return fmt.Errorf("%w: scopes are missing", ErrInvalidToken)
+	}
+
+	for _, scope := range request.Scopes {
+		if !domain.IsScope(scope) {
+			return fmt.Errorf("%w: scope '%s' must be one of: list, read, write, delete, admin", ErrInvalidToken, scope)
+		}
+	}
+
+	prefixes, err := domain.ParsePrefixes(request.Prefixes)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidToken, err.Error())
+	}
+	request.Prefixes = prefixes
+
+	for _, cidr := range request.Cidrs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("%w: invalid CIDR range '%s'", ErrInvalidToken, cidr)
+		}
+	}
+
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: expiry date must be in the future", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+func newTokenSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+func getToken(tx *bolt.Tx, id string) (*IApiToken, error) {
+	value := tx.Bucket(tokensBucket).Get([]byte(id))
+	if value == nil {
+		return nil, ErrTokenNotFound
+	}
+
+	var stored storedApiToken
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return nil, err
+	}
+
+	token := stored.IApiToken
+	token.Hash = stored.Hash
+
+	return &token, nil
+}
+
+func putToken(tx *bolt.Tx, token *IApiToken) error {
+	value, err := json.Marshal(storedApiToken{IApiToken: *token, Hash: token.Hash})
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(tokensBucket).Put([]byte(token.Id), value)
+}
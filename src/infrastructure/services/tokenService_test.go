@@ -0,0 +1,195 @@
+package services
+
+import (
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"slices"
+	"storage-api/src/domain"
+	"testing"
+	"time"
+)
+
+func testTokenService(t *testing.T) *ITokenService {
+	t.Helper()
+
+	config := domain.CONFIG
+	domain.CONFIG = &domain.IConfig{
+		ApiKeys: []*domain.IApiKey{{Name: "token", Hash: domain.HashApiKey("admin"), Scopes: []string{domain.ScopeAdmin}}},
+	}
+	t.Cleanup(func() { domain.CONFIG = config })
+
+	tokens, err := openTokenStore(filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = tokens.db.Close() })
+
+	return tokens
+}
+
+func TestTokenAuthenticate(t *testing.T) {
+	tokens := testTokenService(t)
+
+	token, secret, err := tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeRead}, Prefixes: []string{"/builds//"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if !slices.Equal(token.Prefixes, []string{"builds"}) {
+		t.Fatalf("prefixes = %v, want the canonical path", token.Prefixes)
+	}
+
+	authenticated, err := tokens.Authenticate(secret, "10.1.2.3")
+	if err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+
+	if authenticated.Id != token.Id || !authenticated.ApiKey().AllowsPath("builds/app.zip") || authenticated.ApiKey().AllowsPath("other") {
+		t.Fatalf("Authenticate() = %+v, want the created token", authenticated)
+	}
+
+	if _, err = tokens.Authenticate(secret+"x", "10.1.2.3"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("Authenticate() with another secret error = %v, want %v", err, ErrTokenNotFound)
+	}
+
+	listed, err := tokens.GetTokens()
+	if err != nil || len(listed) != 1 {
+		t.Fatalf("GetTokens() = %v, %v, want the token", listed, err)
+	}
+
+	if listed[0].LastUsedAt == nil || listed[0].LastUsedIp != "10.1.2.3" {
+		t.Fatalf("last use = %v from %q, want it recorded", listed[0].LastUsedAt, listed[0].LastUsedIp)
+	}
+}
+
+func TestTokenAuthenticateExpiry(t *testing.T) {
+	tokens := testTokenService(t)
+
+	expiresAt := time.Now().Add(time.Hour)
+	token, secret, err := tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeRead}, ExpiresAt: &expiresAt})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, err = tokens.Authenticate(secret, "10.1.2.3"); err != nil {
+		t.Fatalf("Authenticate() before the expiry error = %v", err)
+	}
+
+	// Move the expiry to the past, which the API does not allow.
+	expired := time.Now().Add(-time.Minute)
+	token.ExpiresAt = &expired
+	if err = tokens.db.Update(func(tx *bolt.Tx) error { return putToken(tx, token) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tokens.Authenticate(secret, "10.1.2.3"); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("Authenticate() after the expiry error = %v, want %v", err, ErrTokenExpired)
+	}
+}
+
+func TestTokenAuthenticateCidr(t *testing.T) {
+	tokens := testTokenService(t)
+
+	_, secret, err := tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeRead}, Cidrs: []string{"10.0.0.0/8", "2001:db8::/32"}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	tests := []struct {
+		ip  string
+		err error
+	}{
+		{ip: "10.1.2.3"},
+		{ip: "2001:db8::1"},
+		{ip: "11.1.2.3", err: ErrTokenIp},
+		{ip: "2001:db9::1", err: ErrTokenIp},
+		{ip: "10.1.2.3, 11.1.2.3", err: ErrTokenIp},
+		{ip: "", err: ErrTokenIp},
+	}
+
+	for _, test := range tests {
+		if _, err = tokens.Authenticate(secret, test.ip); !errors.Is(err, test.err) {
+			t.Fatalf("Authenticate() from %q error = %v, want %v", test.ip, err, test.err)
+		}
+	}
+}
+
+func TestTokenRevokeAndRotate(t *testing.T) {
+	tokens := testTokenService(t)
+
+	token, secret, err := tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeRead}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	_, rotated, err := tokens.Rotate(token.Id)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+
+	if _, err = tokens.Authenticate(secret, "10.1.2.3"); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("Authenticate() with the rotated secret error = %v, want %v", err, ErrTokenNotFound)
+	}
+
+	if _, err = tokens.Authenticate(rotated, "10.1.2.3"); err != nil {
+		t.Fatalf("Authenticate() with the new secret error = %v", err)
+	}
+
+	if _, err = tokens.Revoke(token.Id); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	if _, err = tokens.Authenticate(rotated, "10.1.2.3"); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Authenticate() with a revoked token error = %v, want %v", err, ErrTokenRevoked)
+	}
+
+	if _, _, err = tokens.Rotate(token.Id); !errors.Is(err, ErrTokenRevoked) {
+		t.Fatalf("Rotate() of a revoked token error = %v, want %v", err, ErrTokenRevoked)
+	}
+}
+
+func TestTokenCreateName(t *testing.T) {
+	tokens := testTokenService(t)
+
+	token, _, err := tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeRead}})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+
+	if _, _, err = tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeWrite}}); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("Create() with a used name error = %v, want %v", err, ErrInvalidToken)
+	}
+
+	if _, err = tokens.Revoke(token.Id); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	if _, _, err = tokens.Create(ITokenRequest{Name: "ci", Scopes: []string{domain.ScopeWrite}}); err != nil {
+		t.Fatalf("Create() with the name of a revoked token error = %v", err)
+	}
+}
+
+func TestTokenCreateInvalid(t *testing.T) {
+	tokens := testTokenService(t)
+	past := time.Now().Add(-time.Hour)
+
+	tests := map[string]ITokenRequest{
+		"missing name":       {Scopes: []string{domain.ScopeRead}},
+		"configured key":     {Name: "token", Scopes: []string{domain.ScopeRead}},
+		"missing scopes":     {Name: "ci"},
+		"unknown scope":      {Name: "ci", Scopes: []string{"root"}},
+		"parent prefix":      {Name: "ci", Scopes: []string{domain.ScopeRead}, Prefixes: []string{"builds/../secret"}},
+		"root prefix":        {Name: "ci", Scopes: []string{domain.ScopeRead}, Prefixes: []string{"/"}},
+		"invalid CIDR":       {Name: "ci", Scopes: []string{domain.ScopeRead}, Cidrs: []string{"10.0.0.1"}},
+		"expiry in the past": {Name: "ci", Scopes: []string{domain.ScopeRead}, ExpiresAt: &past},
+	}
+
+	for name, request := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := tokens.Create(request); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("Create() error = %v, want %v", err, ErrInvalidToken)
+			}
+		})
+	}
+}
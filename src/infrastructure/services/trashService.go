@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/gofiber/fiber/v3/log"
+	"storage-api/src/domain"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TrashPrefix               = ".trash/"
+	TrashOriginalPathMetadata = "original-path"
+	TrashDeletedAtMetadata    = "deleted-at"
+)
+
+type ITrashItem struct {
+	Id           string    `json:"id"`
+	OriginalPath string    `json:"originalPath"`
+	Size         int64     `json:"size"`
+	DeletedAt    time.Time `json:"deletedAt"`
+}
+
+type ITrashService struct {
+	storage   *ICloudflareService
+	dedupe    *IDedupeService
+	retention time.Duration
+}
+
+func TrashService(storage *ICloudflareService) *ITrashService {
+	return &ITrashService{
+		storage:   storage,
+		dedupe:    DedupeService(storage),
+		retention: time.Duration(domain.CONFIG.TrashRetentionDays) * 24 * time.Hour,
+	}
+}
+
+// MoveToTrash copies the object under the trash prefix and removes the original.
+// The returned id identifies the trashed item for a later restore.
+func (s *ITrashService) MoveToTrash(filename string) (string, error) {
+	deletedAt := time.Now().UTC()
+	id := strconv.FormatInt(deletedAt.UnixNano(), 10) + "/" + filename
+
+	err := s.dedupe.CopyFile(filename, TrashPrefix+id, map[string]string{
+		TrashOriginalPathMetadata: filename,
+		TrashDeletedAtMetadata:    deletedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err = s.dedupe.DeleteFile(filename); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (s *ITrashService) GetItems() ([]ITrashItem, error) {
+	objects, err := s.storage.GetAllFiles(TrashPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]ITrashItem, 0, len(objects))
+	for _, object := range objects {
+		item, errItem := trashItem(*object.Key, *object.Size)
+		if errItem != nil {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// Restore moves a trashed item back to its original path. An existing file at
+// that path is only replaced when overwrite is true.
+func (s *ITrashService) Restore(id string, overwrite bool) (string, error) {
+	item, err := trashItem(TrashPrefix+id, 0)
+	if err != nil {
+		return "", err
+	}
+
+	trashed, err := s.storage.HeadFile(TrashPrefix + id)
+	if err != nil {
+		return "", err
+	}
+
+	// The restored file counts again in the quota of its folder, less the file
+	// it replaces. The quota is reserved before the copy and given back if it fails.
+	quotaBytes, quotaObjects := StoredSize(trashed), int64(1)
+
+	current, err := s.storage.HeadFile(item.OriginalPath)
+	switch {
+	case err == nil && !overwrite:
+		return "", fmt.Errorf("%w: %s", ErrFileAlreadyExists, item.OriginalPath)
+	case err == nil:
+		quotaBytes -= StoredSize(current)
+		quotaObjects = 0
+	case !errors.Is(err, ErrFileNotExist):
+		return "", err
+	}
+
+	if err = ReserveQuota(item.OriginalPath, quotaBytes, quotaObjects); err != nil {
+		return "", err
+	}
+
+	err = s.dedupe.CopyFile(TrashPrefix+id, item.OriginalPath, map[string]string{
+		TrashOriginalPathMetadata: "",
+		TrashDeletedAtMetadata:    "",
+	})
+	if err != nil {
+		RecordUsage(item.OriginalPath, -quotaBytes, -quotaObjects)
+		return "", err
+	}
+
+	if err = s.dedupe.DeleteFile(TrashPrefix + id); err != nil {
+		return "", err
+	}
+
+	return item.OriginalPath, nil
+}
+
+// Purge permanently removes every trashed item older than the configured
+// retention, along with the versions, variants and thumbnails of files that
+// were not uploaded again.
+func (s *ITrashService) Purge() (int, error) {
+	items, err := s.GetItems()
+	if err != nil {
+		return 0, err
+	}
+
+	limit := time.Now().Add(-s.retention)
+
+	purged := 0
+	for _, item := range items {
+		if item.DeletedAt.After(limit) {
+			continue
+		}
+
+		if errDelete := s.dedupe.DeleteFile(TrashPrefix + item.Id); errDelete != nil {
+			domain.Logger.Error(errDelete.Error())
+			continue
+		}
+
+		purged++
+
+		// The versions, variants and thumbnails stay while the file can be
+		// restored, and belong to the new file when one was uploaded to the same path.
+		exists, errExists := s.storage.FileExists(item.OriginalPath)
+		if errExists != nil || exists {
+			continue
+		}
+
+		if _, errVersions := VersionService(s.storage).DeleteVersions(item.OriginalPath); errVersions != nil {
+			domain.Logger.Error("Error deleting versions of " + item.OriginalPath + ": " + errVersions.Error())
+		}
+
+		if errVariants := ImageService(s.storage).DeleteVariants(item.OriginalPath); errVariants != nil {
+			domain.Logger.Error(errVariants.Error())
+		}
+
+		if errThumbnails := ThumbnailService(s.storage).Delete(item.OriginalPath); errThumbnails != nil {
+			domain.Logger.Error(errThumbnails.Error())
+		}
+	}
+
+	return purged, nil
+}
+
+func trashItem(key string, size int64) (ITrashItem, error) {
+	id := strings.TrimPrefix(key, TrashPrefix)
+
+	separator := strings.Index(id, "/")
+	if separator <= 0 || separator == len(id)-1 {
+		return ITrashItem{}, fmt.Errorf("Invalid trash item: %s", id)
+	}
+
+	timestamp, err := strconv.ParseInt(id[:separator], 10, 64)
+	if err != nil {
+		return ITrashItem{}, fmt.Errorf("Invalid trash item: %s", id)
+	}
+
+	return ITrashItem{
+		Id:           id,
+		OriginalPath: id[separator+1:],
+		Size:         size,
+		DeletedAt:    time.Unix(0, timestamp).UTC(),
+	}, nil
+}
+
+func TrashCollector() {
+	go func() {
+		storage := CloudflareService()
+		if storage == nil {
+			return
+		}
+
+		trash := TrashService(storage)
+
+		for range time.Tick(1 * time.Hour) {
+			purged, err := trash.Purge()
+			if err != nil {
+				domain.Logger.Error("Error purging trash: " + err.Error())
+				continue
+			}
+
+			if purged > 0 {
+				text := fmt.Sprintf("Trash purged: %d file(s)", purged)
+
+				log.Debug(text)
+				domain.Logger.Debug(text)
+			}
+		}
+	}()
+}
@@ -0,0 +1,219 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	r2 "github.com/aws/aws-sdk-go-v2/service/s3"
+	"sort"
+	"storage-api/src/domain"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	VersionsPrefix              = ".versions/"
+	VersionLastModifiedMetadata = "version-last-modified"
+)
+
+type IFileVersion struct {
+	Id         string    `json:"id"`
+	Size       int64     `json:"size"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+type IVersionService struct {
+	storage  *ICloudflareService
+	dedupe   *IDedupeService
+	maxCount int
+	maxAge   time.Duration
+}
+
+func VersionService(storage *ICloudflareService) *IVersionService {
+	return &IVersionService{
+		storage:  storage,
+		dedupe:   DedupeService(storage),
+		maxCount: domain.CONFIG.VersionsMaxCount,
+		maxAge:   time.Duration(domain.CONFIG.VersionsMaxAgeDays) * 24 * time.Hour,
+	}
+}
+
+// SaveVersion keeps a copy of the current content of filename under the versions
+// prefix and prunes older versions beyond the configured limits.
+func (s *IVersionService) SaveVersion(filename string) (string, error) {
+	id, err := s.copyVersion(filename)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err = s.Prune(filename, s.maxCount, s.maxAge); err != nil {
+		domain.Logger.Error("Error pruning versions of " + filename + ": " + err.Error())
+	}
+
+	return id, nil
+}
+
+func (s *IVersionService) copyVersion(filename string) (string, error) {
+	head, err := s.storage.HeadFile(filename)
+	if err != nil {
+		return "", err
+	}
+
+	id := strconv.FormatInt(time.Now().UTC().UnixNano(), 10)
+
+	metadata := map[string]string{}
+	if head.LastModified != nil {
+		metadata[VersionLastModifiedMetadata] = head.LastModified.UTC().Format(time.RFC3339)
+	}
+
+	if err = s.dedupe.CopyFile(filename, versionKey(filename, id), metadata); err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// GetVersions returns the stored versions of filename, newest first.
+func (s *IVersionService) GetVersions(filename string) ([]IFileVersion, error) {
+	prefix := VersionsPrefix + filename + "/"
+
+	objects, err := s.storage.GetAllFiles(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]IFileVersion, 0, len(objects))
+	for _, object := range objects {
+		id := strings.TrimPrefix(*object.Key, prefix)
+
+		timestamp, errId := strconv.ParseInt(id, 10, 64)
+		if errId != nil {
+			continue
+		}
+
+		versions = append(versions, IFileVersion{
+			Id:         id,
+			Size:       *object.Size,
+			ReplacedAt: time.Unix(0, timestamp).UTC(),
+		})
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ReplacedAt.After(versions[j].ReplacedAt)
+	})
+
+	return versions, nil
+}
+
+func (s *IVersionService) GetVersion(filename string, id string) (*r2.GetObjectOutput, error) {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, fmt.Errorf("Invalid version: %s", id)
+	}
+
+	return s.dedupe.GetFile(versionKey(filename, id))
+}
+
+// Restore replaces the current content of filename with the given version. The
+// content being replaced is kept as a new version. The quota of the folder is
+// reserved for the difference before the copy, and given back if it fails.
+func (s *IVersionService) Restore(filename string, id string) error {
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return fmt.Errorf("Invalid version: %s", id)
+	}
+
+	version, err := s.storage.HeadFile(versionKey(filename, id))
+	if err != nil {
+		if errors.Is(err, ErrFileNotExist) {
+			return fmt.Errorf("Version is not exist")
+		}
+
+		return err
+	}
+
+	quotaBytes, quotaObjects := StoredSize(version), int64(1)
+
+	current, err := s.storage.HeadFile(filename)
+	if err == nil {
+		quotaBytes -= StoredSize(current)
+		quotaObjects = 0
+	} else if !errors.Is(err, ErrFileNotExist) {
+		return err
+	}
+
+	if err = ReserveQuota(filename, quotaBytes, quotaObjects); err != nil {
+		return err
+	}
+
+	if current != nil {
+		if _, err = s.copyVersion(filename); err != nil {
+			RecordUsage(filename, -quotaBytes, -quotaObjects)
+			return err
+		}
+	}
+
+	err = s.dedupe.CopyFile(versionKey(filename, id), filename, map[string]string{
+		VersionLastModifiedMetadata: "",
+	})
+	if err != nil {
+		RecordUsage(filename, -quotaBytes, -quotaObjects)
+		return err
+	}
+
+	if _, err = s.Prune(filename, s.maxCount, s.maxAge); err != nil {
+		domain.Logger.Error("Error pruning versions of " + filename + ": " + err.Error())
+	}
+
+	return nil
+}
+
+// Prune removes the versions of filename beyond maxCount or older than maxAge.
+// A zero value disables the corresponding limit.
+func (s *IVersionService) Prune(filename string, maxCount int, maxAge time.Duration) (int, error) {
+	versions, err := s.GetVersions(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	limit := time.Now().Add(-maxAge)
+
+	pruned := 0
+	for index, version := range versions {
+		expiredCount := maxCount > 0 && index >= maxCount
+		expiredAge := maxAge > 0 && version.ReplacedAt.Before(limit)
+		if !expiredCount && !expiredAge {
+			continue
+		}
+
+		if errDelete := s.dedupe.DeleteFile(versionKey(filename, version.Id)); errDelete != nil {
+			return pruned, errDelete
+		}
+
+		pruned++
+	}
+
+	return pruned, nil
+}
+
+// DeleteVersions removes every version of filename, once the file itself is
+// permanently deleted.
+func (s *IVersionService) DeleteVersions(filename string) (int, error) {
+	versions, err := s.GetVersions(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, version := range versions {
+		if errDelete := s.dedupe.DeleteFile(versionKey(filename, version.Id)); errDelete != nil {
+			return deleted, errDelete
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func versionKey(filename string, id string) string {
+	return VersionsPrefix + filename + "/" + id
+}